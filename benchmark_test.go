@@ -3,6 +3,7 @@ package mbserver
 import (
 	"fmt"
 	"log"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -40,6 +41,7 @@ func serverClientSetup() *serverClient {
 
 	// Client
 	setup.clientTCPHandler = modbus.NewTCPClientHandler(addr)
+	setup.clientTCPHandler.SlaveId = 1
 	// Connect manually so that multiple requests are handled in one connection session
 	setup.err = setup.clientTCPHandler.Connect()
 	if setup.err != nil {
@@ -136,6 +138,112 @@ func BenchmarkModbusRead125HoldingRegisters(b *testing.B) {
 	}
 }
 
+// parallelSlaveNum is the number of slaves the parallel clients address.
+const parallelSlaveNum = 16
+
+// benchmarkParallelClients runs request from 8 clients per CPU, each with its
+// own connection and the slaves shared out between them.
+func benchmarkParallelClients(b *testing.B, slaver Slaver, concurrent bool, request func(client modbus.Client) error) {
+	slave := NewServer(slaver)
+	slave.ConcurrentDispatch = concurrent
+	addr := getFreePort()
+	if err := slave.ListenTCP(addr); err != nil {
+		b.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer slave.Close()
+
+	var clients uint32
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		handler := modbus.NewTCPClientHandler(addr)
+		handler.SlaveId = byte(atomic.AddUint32(&clients, 1)%parallelSlaveNum + 1)
+		if err := handler.Connect(); err != nil {
+			b.Errorf("failed to connect, got %v\n", err)
+			return
+		}
+		defer handler.Close()
+		client := modbus.NewClient(handler)
+
+		for pb.Next() {
+			if err := request(client); err != nil {
+				b.Errorf("expected nil, got %v\n", err)
+				return
+			}
+		}
+	})
+}
+
+func write123MultipleRegisters(client modbus.Client) error {
+	_, err := client.WriteMultipleRegisters(1, 123, make([]byte, 246))
+	return err
+}
+
+func read125HoldingRegisters(client modbus.Client) error {
+	_, err := client.ReadHoldingRegisters(1, 125)
+	return err
+}
+
+func BenchmarkModbusParallelRead125HoldingRegisters(b *testing.B) {
+	benchmarkParallelClients(b, NewMemorySlaveUint8(parallelSlaveNum), false, read125HoldingRegisters)
+}
+
+func BenchmarkModbusParallelRead125HoldingRegistersConcurrent(b *testing.B) {
+	benchmarkParallelClients(b, NewMemorySlaveUint8(parallelSlaveNum), true, read125HoldingRegisters)
+}
+
+// slowSlaver takes a millisecond to save holding registers, as a slave on a
+// slow disk or behind a gateway does.
+type slowSlaver struct {
+	Slaver
+}
+
+func (s slowSlaver) SaveHoldingRegisters(id uint8, holdingRegisters []uint16) error {
+	time.Sleep(time.Millisecond)
+	return s.Slaver.SaveHoldingRegisters(id, holdingRegisters)
+}
+
+func BenchmarkModbusParallelSlowWrite123MultipleRegisters(b *testing.B) {
+	benchmarkParallelClients(b, slowSlaver{NewMemorySlaveUint8(parallelSlaveNum)}, false, write123MultipleRegisters)
+}
+
+func BenchmarkModbusParallelSlowWrite123MultipleRegistersConcurrent(b *testing.B) {
+	benchmarkParallelClients(b, slowSlaver{NewMemorySlaveUint8(parallelSlaveNum)}, true, write123MultipleRegisters)
+}
+
+func BenchmarkModbusParallelFileWrite123MultipleRegisters(b *testing.B) {
+	benchmarkParallelClients(b, NewFileSlaveUint8(parallelSlaveNum, b.TempDir()), false, write123MultipleRegisters)
+}
+
+func BenchmarkModbusParallelFileWrite123MultipleRegistersConcurrent(b *testing.B) {
+	benchmarkParallelClients(b, NewFileSlaveUint8(parallelSlaveNum, b.TempDir()), true, write123MultipleRegisters)
+}
+
+// BenchmarkFileSlaveWriteRegister writes one register in place, through the journal.
+func BenchmarkFileSlaveWriteRegister(b *testing.B) {
+	slaver := NewFileSlaveUint8(1, b.TempDir()).(*fileSlaveUint8)
+	slaver.SaveHoldingRegisters(1, make([]uint16, 65536))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := slaver.WriteHoldingRegisters(1, uint16(i), []uint16{uint16(i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFileSlaveSaveRegisters rewrites the whole table for one register, as the ranged writes did before the journal.
+func BenchmarkFileSlaveSaveRegisters(b *testing.B) {
+	slaver := NewFileSlaveUint8(1, b.TempDir()).(*fileSlaveUint8)
+	values := make([]uint16, 65536)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		values[uint16(i)] = uint16(i)
+		if err := slaver.SaveHoldingRegisters(1, values); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // Start a Modbus server and use a client to write to and read from the serer.
 func Example() {
 	// Start the server.
@@ -152,6 +260,7 @@ func Example() {
 
 	// Connect a client.
 	handler := modbus.NewTCPClientHandler("localhost:1502")
+	handler.SlaveId = 1
 	err = handler.Connect()
 	if err != nil {
 		log.Printf("%v\n", err)
@@ -221,6 +330,7 @@ func ExampleServer_RegisterFunctionHandler() {
 
 	// Connect a client.
 	handler := modbus.NewTCPClientHandler("localhost:4321")
+	handler.SlaveId = 1
 	err = handler.Connect()
 	if err != nil {
 		log.Printf("%v\n", err)
@@ -0,0 +1,198 @@
+package mbserver
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooManyConnections is returned by ServeConn when MaxConnections are
+// handling requests, so that none can be closed for the new connection.
+var ErrTooManyConnections = errors.New("mbserver: too many connections")
+
+// serverConn is a connection accepted by a listener of the server, or served by ServeConn.
+type serverConn struct {
+	net.Conn
+	// policy authorizes the requests of an mbaps connection by role.
+	policy *RolePolicy
+	// origin is the origin of the requests of the connection, see
+	// Request.origin. Only the goroutine of the connection uses it.
+	origin string
+	// The fields below are guarded by Server.lock.
+	// idle is set while the connection waits for a request.
+	idle bool
+	// closed is set once the server closed the connection.
+	closed bool
+	// role is the role of an mbaps connection, set once its handshake is done.
+	role        string
+	started     time.Time
+	lastRequest time.Time
+	requests    uint64
+}
+
+// ConnectionInfo describes a connection of the server, see Connections.
+type ConnectionInfo struct {
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// Started is the time the connection was accepted.
+	Started time.Time
+	// Uptime is the time since Started.
+	Uptime time.Duration
+	// Requests counts the requests received on the connection.
+	Requests uint64
+	// LastRequest is the time of the last request, Started if none.
+	LastRequest time.Time
+	// Idle is set while the connection waits for a request.
+	Idle bool
+	// Role is the role of the client certificate of an mbaps connection, see ListenMBAPS.
+	Role string
+}
+
+// Connections returns the connections of the TCP listeners and ServeConn, the oldest first.
+func (s *Server) Connections() []ConnectionInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	infos := make([]ConnectionInfo, 0, len(s.conns))
+	for conn := range s.conns {
+		infos = append(infos, ConnectionInfo{
+			RemoteAddr:  conn.RemoteAddr(),
+			LocalAddr:   conn.LocalAddr(),
+			Started:     conn.started,
+			Uptime:      now.Sub(conn.started),
+			Requests:    conn.requests,
+			LastRequest: conn.lastRequest,
+			Idle:        conn.idle,
+			Role:        conn.role,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
+
+// newServerConn returns the state of a new connection and sets its TCP keep-alive, see KeepAlive.
+func (s *Server) newServerConn(conn net.Conn) *serverConn {
+	tcpConn := conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tcpConn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := tcpConn.(*net.TCPConn); ok && s.KeepAlive != 0 {
+		tcpConn.SetKeepAlive(s.KeepAlive > 0)
+		if s.KeepAlive > 0 {
+			tcpConn.SetKeepAlivePeriod(s.KeepAlive)
+		}
+	}
+	now := time.Now()
+	c := &serverConn{Conn: conn, started: now, lastRequest: now}
+	if addr := conn.RemoteAddr(); addr != nil {
+		c.origin = addr.String()
+	}
+	return c
+}
+
+// trackConn adds a new connection, the caller holds the lock. At MaxConnections
+// it closes the connection idle for the longest time, ErrTooManyConnections if none is idle.
+func (s *Server) trackConn(conn *serverConn) error {
+	if s.MaxConnections > 0 && len(s.conns) >= s.MaxConnections {
+		var oldest *serverConn
+		for c := range s.conns {
+			if c.idle && (oldest == nil || c.lastRequest.Before(oldest.lastRequest)) {
+				oldest = c
+			}
+		}
+		if oldest == nil {
+			return ErrTooManyConnections
+		}
+		s.closeIdleConn(oldest)
+		delete(s.conns, oldest)
+	}
+	s.conns[conn] = struct{}{}
+	return nil
+}
+
+// untrackConn removes a connection closed by its goroutine.
+func (s *Server) untrackConn(conn *serverConn) {
+	s.lock.Lock()
+	delete(s.conns, conn)
+	s.lock.Unlock()
+}
+
+// closeConn closes a connection from outside its goroutine, the caller holds the lock.
+func (s *Server) closeConn(conn *serverConn) {
+	conn.closed = true
+	conn.Close()
+}
+
+// closeIdleConn ends an idle connection from outside its goroutine, the caller
+// holds the lock. Its read is interrupted rather than the connection closed,
+// so a request read just before is still answered, then its goroutine closes it.
+func (s *Server) closeIdleConn(conn *serverConn) {
+	conn.closed = true
+	conn.SetReadDeadline(time.Now())
+}
+
+// isConnClosed reports whether the server closed the connection, so its read error is expected.
+func (s *Server) isConnClosed(conn *serverConn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return conn.closed || s.closing
+}
+
+// setConnDeadline sets the read and write deadline of a connection, unless the
+// server closed it, which it reports false, so it does not undo closeIdleConn.
+func (s *Server) setConnDeadline(conn *serverConn, deadline time.Time) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if conn.closed {
+		return false
+	}
+	conn.SetDeadline(deadline)
+	return true
+}
+
+// setConnIdle marks a connection waiting for a request or handling one, it
+// reports false if the server closed the connection, or is shutting down and
+// the connection is to be closed. The read deadline of an idle connection is IdleTimeout.
+func (s *Server) setConnIdle(conn *serverConn, idle bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	conn.idle = idle
+	if !idle {
+		conn.requests++
+		conn.lastRequest = time.Now()
+	}
+	if conn.closed || idle && s.closing {
+		return false
+	}
+	if s.IdleTimeout > 0 {
+		var deadline time.Time
+		if idle {
+			deadline = time.Now().Add(s.IdleTimeout)
+		}
+		conn.SetReadDeadline(deadline)
+	}
+	return true
+}
+
+// write writes the response of a request, within WriteTimeout for a
+// connection. A connection failing to take a response is closed, its stream
+// of responses is broken.
+func (s *Server) write(request *Request, response Framer) {
+	conn, ok := request.conn.(*serverConn)
+	if ok && s.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+	if _, err := request.conn.Write(response.Bytes()); err != nil && ok {
+		log.Printf("write error to %s: %s\n", conn.RemoteAddr(), err.Error())
+		s.lock.Lock()
+		s.closeConn(conn)
+		s.lock.Unlock()
+	}
+}
@@ -0,0 +1,194 @@
+package mbserver
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Device identification object ids. Objects 0x00-0x02 are basic, 0x03-0x7F
+// regular and 0x80-0xFF extended (private) objects.
+const (
+	DeviceIdVendorName          uint8 = 0x00
+	DeviceIdProductCode         uint8 = 0x01
+	DeviceIdMajorMinorRevision  uint8 = 0x02
+	DeviceIdVendorUrl           uint8 = 0x03
+	DeviceIdProductName         uint8 = 0x04
+	DeviceIdModelName           uint8 = 0x05
+	DeviceIdUserApplicationName uint8 = 0x06
+)
+
+// Read device id codes of Read Device Identification.
+const (
+	readDeviceIdBasic      = 0x01
+	readDeviceIdRegular    = 0x02
+	readDeviceIdExtended   = 0x03
+	readDeviceIdIndividual = 0x04
+)
+
+// MEI type of Read Device Identification.
+const meiReadDeviceIdentification = 0x0E
+
+// deviceIdMaxObjectsLength is the room left for objects in a response PDU:
+// 253 bytes less the function code, MEI type, read device id code,
+// conformity level, more follows, next object id and number of objects.
+const deviceIdMaxObjectsLength = 253 - 7
+
+// DeviceIdentification holds the objects a slave returns for Read Device Identification.
+type DeviceIdentification struct {
+	lock    sync.RWMutex
+	objects [256]*string
+}
+
+// NewDeviceIdentification creates the device identification with the three mandatory basic objects.
+func NewDeviceIdentification(vendorName, productCode, majorMinorRevision string) (*DeviceIdentification, error) {
+	var d = new(DeviceIdentification)
+	for id, value := range []string{vendorName, productCode, majorMinorRevision} {
+		if err := d.SetObject(uint8(id), value); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// SetObject sets a basic, regular or extended object. An object must fit in
+// one response, that is at most 244 bytes.
+func (d *DeviceIdentification) SetObject(id uint8, value string) error {
+	if len(value) > deviceIdMaxObjectsLength-2 {
+		return errors.Errorf("device identification object 0x%02x is %d bytes, more than %d", id, len(value), deviceIdMaxObjectsLength-2)
+	}
+	d.lock.Lock()
+	d.objects[id] = &value
+	d.lock.Unlock()
+	return nil
+}
+
+// DeleteObject removes an object.
+func (d *DeviceIdentification) DeleteObject(id uint8) {
+	d.lock.Lock()
+	d.objects[id] = nil
+	d.lock.Unlock()
+}
+
+// Object returns an object and whether it is set.
+func (d *DeviceIdentification) Object(id uint8) (value string, ok bool) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	if d.objects[id] == nil {
+		return "", false
+	}
+	return *d.objects[id], true
+}
+
+// conformityLevel reports the highest category with objects, individual access is always supported.
+func (d *DeviceIdentification) conformityLevel() byte {
+	level := byte(readDeviceIdBasic)
+	for id := 0x03; id <= 0xFF; id++ {
+		if d.objects[id] == nil {
+			continue
+		}
+		if id >= 0x80 {
+			level = readDeviceIdExtended
+			break
+		}
+		level = readDeviceIdRegular
+	}
+	return 0x80 | level
+}
+
+// SetDeviceIdentification sets the objects returned by Read Device
+// Identification for slave id, nil disables the function for the slave.
+func (s *Server) SetDeviceIdentification(id uint8, identification *DeviceIdentification) {
+	s.deviceIdentificationsLock.Lock()
+	s.deviceIdentifications[id] = identification
+	s.deviceIdentificationsLock.Unlock()
+}
+
+// DeviceIdentification returns the device identification of slave id, nil if it has none.
+func (s *Server) DeviceIdentification(id uint8) *DeviceIdentification {
+	s.deviceIdentificationsLock.RLock()
+	defer s.deviceIdentificationsLock.RUnlock()
+	return s.deviceIdentifications[id]
+}
+
+// RegisterMEIHandler override the default behavior for a given MEI type of
+// the Encapsulated Interface Transport (function 43). The function gets the
+// whole request data, starting with the MEI type, and returns the response
+// data, also starting with the MEI type.
+func (s *Server) RegisterMEIHandler(meiType uint8, function func(*Server, Framer) ([]byte, *Exception)) {
+	s.mei[meiType] = function
+}
+
+// EncapsulatedInterfaceTransport function 43, dispatches the request on its MEI type.
+func EncapsulatedInterfaceTransport(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 1 {
+		return []byte{}, &IllegalDataValue
+	}
+	if s.mei[data[0]] == nil {
+		return []byte{}, &IllegalFunction
+	}
+	return s.mei[data[0]](s, frame)
+}
+
+// ReadDeviceIdentification function 43 MEI type 14, reads the device identification objects.
+// Stream access responses that do not fit in one PDU are split with "more follows".
+func ReadDeviceIdentification(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) != 3 {
+		return []byte{}, &IllegalDataValue
+	}
+	readDeviceIdCode, objectId := data[1], data[2]
+
+	identification := s.DeviceIdentification(frame.Addr())
+	if identification == nil {
+		return []byte{}, &IllegalFunction
+	}
+	identification.lock.RLock()
+	defer identification.lock.RUnlock()
+
+	var lastObjectId int
+	switch readDeviceIdCode {
+	case readDeviceIdBasic:
+		lastObjectId = 0x02
+	case readDeviceIdRegular:
+		lastObjectId = 0x7F
+	case readDeviceIdExtended:
+		lastObjectId = 0xFF
+	case readDeviceIdIndividual:
+		value := identification.objects[objectId]
+		if value == nil {
+			return []byte{}, &IllegalDataAddress
+		}
+		response := []byte{meiReadDeviceIdentification, readDeviceIdCode, identification.conformityLevel(), 0x00, 0x00, 1}
+		response = append(response, objectId, byte(len(*value)))
+		return append(response, *value...), &Success
+	default:
+		return []byte{}, &IllegalDataValue
+	}
+
+	// An unknown object id restarts the stream at the first object.
+	if int(objectId) > lastObjectId || identification.objects[objectId] == nil {
+		objectId = 0
+	}
+
+	response := []byte{meiReadDeviceIdentification, readDeviceIdCode, identification.conformityLevel(), 0x00, 0x00, 0}
+	length := 0
+	for id := int(objectId); id <= lastObjectId; id++ {
+		value := identification.objects[id]
+		if value == nil {
+			continue
+		}
+		if length+2+len(*value) > deviceIdMaxObjectsLength {
+			// More follows, the master asks again starting at this object.
+			response[3] = 0xFF
+			response[4] = byte(id)
+			break
+		}
+		response = append(response, byte(id), byte(len(*value)))
+		response = append(response, *value...)
+		length += 2 + len(*value)
+		response[5]++
+	}
+	return response, &Success
+}
@@ -0,0 +1,139 @@
+package mbserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func readDeviceIdentification(s *Server, readDeviceIdCode, objectId uint8) (Exception, []byte) {
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 43
+	frame.SetData([]byte{0x0E, readDeviceIdCode, objectId})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	return GetException(response), response.GetData()
+}
+
+func TestReadDeviceIdentificationBasic(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	identification, err := NewDeviceIdentification("ACME", "MB-1", "V1.2")
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	s.SetDeviceIdentification(1, identification)
+
+	exception, got := readDeviceIdentification(s, 1, 0)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x0E, 0x01, 0x81, 0x00, 0x00, 3,
+		0x00, 4, 'A', 'C', 'M', 'E',
+		0x01, 4, 'M', 'B', '-', '1',
+		0x02, 4, 'V', '1', '.', '2',
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestReadDeviceIdentificationIndividual(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	identification, _ := NewDeviceIdentification("ACME", "MB-1", "V1.2")
+	identification.SetObject(DeviceIdProductName, "Meter")
+	s.SetDeviceIdentification(1, identification)
+
+	exception, got := readDeviceIdentification(s, 4, DeviceIdProductName)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x0E, 0x04, 0x82, 0x00, 0x00, 1, 0x04, 5, 'M', 'e', 't', 'e', 'r'}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	exception, _ = readDeviceIdentification(s, 4, DeviceIdModelName)
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+}
+
+func TestReadDeviceIdentificationMoreFollows(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	identification, _ := NewDeviceIdentification("ACME", "MB-1", "V1.2")
+	identification.SetObject(0x80, strings.Repeat("a", 200))
+	identification.SetObject(0x81, strings.Repeat("b", 100))
+	s.SetDeviceIdentification(1, identification)
+
+	exception, got := readDeviceIdentification(s, 3, 0)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	// Object 0x81 does not fit after the basic objects and object 0x80.
+	expect := []byte{0x0E, 0x03, 0x83, 0xFF, 0x81, 4}
+	if !isEqual(expect, got[:6]) {
+		t.Errorf("expected %v, got %v", expect, got[:6])
+	}
+
+	exception, got = readDeviceIdentification(s, 3, 0x81)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect = append([]byte{0x0E, 0x03, 0x83, 0x00, 0x00, 1, 0x81, 100}, strings.Repeat("b", 100)...)
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestReadDeviceIdentificationUnknownObjectRestarts(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	identification, _ := NewDeviceIdentification("A", "B", "C")
+	s.SetDeviceIdentification(1, identification)
+
+	exception, got := readDeviceIdentification(s, 2, 0x50)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x0E, 0x02, 0x81, 0x00, 0x00, 3, 0x00, 1, 'A', 0x01, 1, 'B', 0x02, 1, 'C'}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestReadDeviceIdentificationErrors(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	// No identification configured for the slave.
+	exception, _ := readDeviceIdentification(s, 1, 0)
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+
+	identification, _ := NewDeviceIdentification("A", "B", "C")
+	s.SetDeviceIdentification(1, identification)
+	exception, _ = readDeviceIdentification(s, 5, 0)
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+
+	// Unknown MEI type.
+	var frame TCPFrame
+	frame.Device = 1
+	frame.Function = 43
+	frame.SetData([]byte{0x0D, 0x00})
+	var req Request
+	req.frame = &frame
+	exception = GetException(s.handle(&req))
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+}
+
+func TestDeviceIdentificationObjectTooLong(t *testing.T) {
+	identification, _ := NewDeviceIdentification("A", "B", "C")
+	if err := identification.SetObject(0x80, strings.Repeat("a", 245)); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
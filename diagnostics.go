@@ -0,0 +1,367 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// Sub-function codes of Diagnostics (function 8).
+const (
+	diagReturnQueryData                  = 0x00
+	diagRestartCommunicationsOption      = 0x01
+	diagReturnDiagnosticRegister         = 0x02
+	diagChangeASCIIInputDelimiter        = 0x03
+	diagForceListenOnlyMode              = 0x04
+	diagClearCountersAndRegister         = 0x0A
+	diagReturnBusMessageCount            = 0x0B
+	diagReturnBusCommunicationErrorCount = 0x0C
+	diagReturnBusExceptionErrorCount     = 0x0D
+	diagReturnServerMessageCount         = 0x0E
+	diagReturnServerNoResponseCount      = 0x0F
+	diagReturnServerNAKCount             = 0x10
+	diagReturnServerBusyCount            = 0x11
+	diagReturnBusCharacterOverrunCount   = 0x12
+	diagClearOverrunCounterAndFlag       = 0x14
+)
+
+// Communication event log entries, see Get Comm Event Log (function 12).
+const (
+	eventReceive            = 0x80
+	eventReceiveCommError   = 0x02
+	eventReceiveListenOnly  = 0x20
+	eventSend               = 0x40
+	eventSendReadException  = 0x01
+	eventSendAbortException = 0x02
+	eventSendBusyException  = 0x04
+	eventSendNAKException   = 0x08
+	eventEnteredListenOnly  = 0x04
+	eventCommRestart        = 0x00
+	eventLogLength          = 64
+)
+
+// DiagnosticCounters are the serial line counters of a slave, as returned by
+// the Diagnostics (function 8) sub-functions.
+type DiagnosticCounters struct {
+	// BusMessages counts the messages seen on the line, for any slave.
+	BusMessages uint16
+	// BusCommunicationErrors counts the frames dropped for a bad CRC or LRC.
+	BusCommunicationErrors uint16
+	// BusExceptionErrors counts the exception responses of the slave.
+	BusExceptionErrors uint16
+	// ServerMessages counts the messages addressed to the slave.
+	ServerMessages uint16
+	// ServerNoResponses counts the messages the slave did not answer.
+	ServerNoResponses uint16
+	// ServerNAKs counts the Negative Acknowledge exception responses.
+	ServerNAKs uint16
+	// ServerBusy counts the Slave Device Busy exception responses.
+	ServerBusy uint16
+	// BusCharacterOverruns counts the characters lost to overruns.
+	BusCharacterOverruns uint16
+}
+
+// slaveDiagnostics is the serial line state of one slave. Its bus message
+// and communication error counters are the line counters of the server from
+// busMessagesBase and busCommErrorsBase, their values when they were cleared.
+type slaveDiagnostics struct {
+	lock               sync.Mutex
+	counters           DiagnosticCounters
+	busMessagesBase    uint32
+	busCommErrorsBase  uint32
+	diagnosticRegister uint16
+	eventCounter       uint16
+	// events holds the communication event log, most recent first.
+	events          []byte
+	listenOnly      bool
+	exceptionStatus byte
+	serverId        []byte
+	additionalData  []byte
+}
+
+func (d *slaveDiagnostics) addEvent(event byte) {
+	d.events = append([]byte{event}, d.events...)
+	if len(d.events) > eventLogLength {
+		d.events = d.events[:eventLogLength]
+	}
+}
+
+// DiagnosticCounters returns the serial line counters of slave id.
+func (s *Server) DiagnosticCounters(id uint8) DiagnosticCounters {
+	d := &s.diagnostics[id]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return s.counters(d)
+}
+
+// counters returns the counters of d with its bus counters, the caller holds d.lock.
+func (s *Server) counters(d *slaveDiagnostics) DiagnosticCounters {
+	counters := d.counters
+	// The line counters wrap around at 2^32, a multiple of 2^16.
+	counters.BusMessages = uint16(s.busMessages.Load() - d.busMessagesBase)
+	counters.BusCommunicationErrors = uint16(s.busCommErrors.Load() - d.busCommErrorsBase)
+	return counters
+}
+
+// clearCounters clears the counters of d, the caller holds d.lock.
+func (s *Server) clearCounters(d *slaveDiagnostics) {
+	d.counters = DiagnosticCounters{}
+	d.busMessagesBase = s.busMessages.Load()
+	d.busCommErrorsBase = s.busCommErrors.Load()
+}
+
+// SetExceptionStatus sets the eight exception status outputs returned by Read Exception Status (function 7).
+func (s *Server) SetExceptionStatus(id uint8, status byte) {
+	d := &s.diagnostics[id]
+	d.lock.Lock()
+	d.exceptionStatus = status
+	d.lock.Unlock()
+}
+
+// SetServerId sets the device specific server id and additional data returned
+// by Report Server ID (function 17). Without it the server id is the slave id.
+func (s *Server) SetServerId(id uint8, serverId []byte, additionalData []byte) {
+	d := &s.diagnostics[id]
+	d.lock.Lock()
+	d.serverId = CopyBytes(serverId)
+	d.additionalData = CopyBytes(additionalData)
+	d.lock.Unlock()
+}
+
+// countBusMessage counts a message seen on a serial line. Every slave served
+// by the server is on the line, so the bus counters of all slaves change, they
+// are the line counters of the server. A communication error is also logged
+// as an event of every slave, bad frames being rare.
+func (s *Server) countBusMessage(commError bool) {
+	s.busMessages.Add(1)
+	if !commError {
+		return
+	}
+	s.busCommErrors.Add(1)
+	for id := range s.diagnostics {
+		d := &s.diagnostics[id]
+		d.lock.Lock()
+		d.addEvent(eventReceive | eventReceiveCommError)
+		d.lock.Unlock()
+	}
+}
+
+// asciiInputDelimiter returns the last character of ASCII requests, see Change ASCII Input Delimiter.
+func (s *Server) asciiInputDelimiter() byte {
+	return byte(s.asciiDelimiter.Load())
+}
+
+// receive records a request addressed to slave id. It reports false if the
+// slave is in listen only mode and must not process the request.
+func (s *Server) receive(frame Framer) bool {
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.counters.ServerMessages++
+	if !d.listenOnly {
+		d.addEvent(eventReceive)
+		return true
+	}
+	d.addEvent(eventReceive | eventReceiveListenOnly)
+	d.counters.ServerNoResponses++
+
+	// Only Restart Communications Option brings the slave out of listen only mode, without a response.
+	data := frame.GetData()
+	if frame.GetFunction() == 8 && len(data) == 4 && binary.BigEndian.Uint16(data[0:2]) == diagRestartCommunicationsOption {
+		s.restartCommunications(d, data[2] == 0xFF)
+	}
+	return false
+}
+
+// respond records the response of slave id to a request. It reports false if
+// the request put the slave in listen only mode, then no response is sent and
+// none is recorded.
+func (s *Server) respond(frame Framer, exception *Exception) bool {
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.listenOnly {
+		d.counters.ServerNoResponses++
+		return false
+	}
+	event := byte(eventSend)
+	switch *exception {
+	case Success:
+		// Fetching the event counter or log is not counted as an event.
+		if function := frame.GetFunction(); function != 11 && function != 12 {
+			d.eventCounter++
+		}
+	case IllegalFunction, IllegalDataAddress, IllegalDataValue:
+		event |= eventSendReadException
+	case SlaveDeviceFailure:
+		event |= eventSendAbortException
+	case AcknowledgeSlave, SlaveDeviceBusy:
+		event |= eventSendBusyException
+	case NegativeAcknowledge:
+		event |= eventSendNAKException
+	}
+	if *exception != Success {
+		d.counters.BusExceptionErrors++
+	}
+	switch *exception {
+	case SlaveDeviceBusy:
+		d.counters.ServerBusy++
+	case NegativeAcknowledge:
+		d.counters.ServerNAKs++
+	}
+	d.addEvent(event)
+	return true
+}
+
+// restartCommunications clears the counters of d and leaves listen only mode,
+// the event log is cleared too if clearLog. The caller holds d.lock.
+func (s *Server) restartCommunications(d *slaveDiagnostics, clearLog bool) {
+	s.clearCounters(d)
+	d.diagnosticRegister = 0
+	d.eventCounter = 0
+	d.listenOnly = false
+	if clearLog {
+		d.events = nil
+	}
+	d.addEvent(eventCommRestart)
+}
+
+// ReadExceptionStatus function 7, reads the eight exception status outputs.
+func ReadExceptionStatus(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return []byte{d.exceptionStatus}, &Success
+}
+
+// Diagnostics function 8, tests the communication and returns the serial line counters.
+// Force Listen Only Mode returns no response.
+func Diagnostics(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 2 {
+		return []byte{}, &IllegalDataValue
+	}
+	subFunction := binary.BigEndian.Uint16(data[0:2])
+	if subFunction == diagReturnQueryData {
+		return data, &Success
+	}
+	if len(data) != 4 {
+		return []byte{}, &IllegalDataValue
+	}
+	value := binary.BigEndian.Uint16(data[2:4])
+
+	// Except for these two the data field is 0x0000.
+	if value != 0 && subFunction != diagRestartCommunicationsOption && subFunction != diagChangeASCIIInputDelimiter {
+		return []byte{}, &IllegalDataValue
+	}
+
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	var counter uint16
+	switch subFunction {
+	case diagRestartCommunicationsOption:
+		if value != 0x0000 && value != 0xFF00 {
+			return []byte{}, &IllegalDataValue
+		}
+		s.restartCommunications(d, value == 0xFF00)
+		return data, &Success
+	case diagChangeASCIIInputDelimiter:
+		if data[3] != 0 {
+			return []byte{}, &IllegalDataValue
+		}
+		s.asciiDelimiter.Store(uint32(data[2]))
+		return data, &Success
+	case diagForceListenOnlyMode:
+		// The server sends no response once the slave is in listen only mode.
+		d.listenOnly = true
+		d.addEvent(eventEnteredListenOnly)
+		return data, &Success
+	case diagClearCountersAndRegister:
+		s.clearCounters(d)
+		d.diagnosticRegister = 0
+	case diagClearOverrunCounterAndFlag:
+		d.counters.BusCharacterOverruns = 0
+	case diagReturnDiagnosticRegister:
+		counter = d.diagnosticRegister
+	case diagReturnBusMessageCount:
+		counter = s.counters(d).BusMessages
+	case diagReturnBusCommunicationErrorCount:
+		counter = s.counters(d).BusCommunicationErrors
+	case diagReturnBusExceptionErrorCount:
+		counter = d.counters.BusExceptionErrors
+	case diagReturnServerMessageCount:
+		counter = d.counters.ServerMessages
+	case diagReturnServerNoResponseCount:
+		counter = d.counters.ServerNoResponses
+	case diagReturnServerNAKCount:
+		counter = d.counters.ServerNAKs
+	case diagReturnServerBusyCount:
+		counter = d.counters.ServerBusy
+	case diagReturnBusCharacterOverrunCount:
+		counter = d.counters.BusCharacterOverruns
+	default:
+		return []byte{}, &IllegalFunction
+	}
+
+	response := make([]byte, 4)
+	binary.BigEndian.PutUint16(response[0:2], subFunction)
+	binary.BigEndian.PutUint16(response[2:4], counter)
+	return response, &Success
+}
+
+// GetCommEventCounter function 11, returns the status word and the communication event counter.
+func GetCommEventCounter(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// The status word is 0xFFFF while a previous command is in progress, that never happens here.
+	response := make([]byte, 4)
+	binary.BigEndian.PutUint16(response[2:4], d.eventCounter)
+	return response, &Success
+}
+
+// GetCommEventLog function 12, returns the status word, event counter, message count and event log.
+func GetCommEventLog(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	response := make([]byte, 7, 7+len(d.events))
+	response[0] = byte(6 + len(d.events))
+	binary.BigEndian.PutUint16(response[3:5], d.eventCounter)
+	binary.BigEndian.PutUint16(response[5:7], s.counters(d).BusMessages)
+	return append(response, d.events...), &Success
+}
+
+// ReportServerId function 17, returns the server id, the run indicator status and additional data.
+func ReportServerId(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+	d := &s.diagnostics[frame.Addr()]
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	serverId := d.serverId
+	if serverId == nil {
+		serverId = []byte{frame.Addr()}
+	}
+	response := []byte{byte(len(serverId) + 1 + len(d.additionalData))}
+	response = append(response, serverId...)
+	// Run indicator status ON.
+	response = append(response, 0xFF)
+	return append(response, d.additionalData...), &Success
+}
@@ -0,0 +1,208 @@
+package mbserver
+
+import (
+	"testing"
+	"time"
+)
+
+func handleRTU(s *Server, function uint8, data ...byte) Framer {
+	var req Request
+	req.frame = &RTUFrame{Address: 1, Function: function, Data: data}
+	return s.handle(&req)
+}
+
+// Function 7
+func TestReadExceptionStatus(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	s.SetExceptionStatus(1, 0x6D)
+
+	response := handleRTU(s, 7)
+	exception := GetException(response)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x6D}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+// Function 8
+func TestDiagnosticsCounters(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	// Return Query Data echoes the request.
+	response := handleRTU(s, 8, 0x00, 0x00, 0xA5, 0x37)
+	expect := []byte{0x00, 0x00, 0xA5, 0x37}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// An exception response.
+	handleRTU(s, 3, 0xFF, 0xFF, 0x00, 0x02)
+
+	// Return Server Message Count, this request is the third.
+	response = handleRTU(s, 8, 0x00, 0x0E, 0x00, 0x00)
+	expect = []byte{0x00, 0x0E, 0x00, 0x03}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Return Bus Exception Error Count.
+	response = handleRTU(s, 8, 0x00, 0x0D, 0x00, 0x00)
+	expect = []byte{0x00, 0x0D, 0x00, 0x01}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Clear Counters and Diagnostic Register.
+	handleRTU(s, 8, 0x00, 0x0A, 0x00, 0x00)
+	response = handleRTU(s, 8, 0x00, 0x0E, 0x00, 0x00)
+	expect = []byte{0x00, 0x0E, 0x00, 0x01}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// The data field of a counter sub-function must be 0x0000.
+	exception := GetException(handleRTU(s, 8, 0x00, 0x0B, 0x00, 0x01))
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+
+	exception = GetException(handleRTU(s, 8, 0x00, 0x30, 0x00, 0x00))
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+}
+
+func TestDiagnosticsListenOnlyMode(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	// Force Listen Only Mode has no response, neither have later requests.
+	if response := handleRTU(s, 8, 0x00, 0x04, 0x00, 0x00); response != nil {
+		t.Errorf("expected nil, got % x", response.Bytes())
+	}
+	if response := handleRTU(s, 6, 0x00, 0x01, 0x00, 0x03); response != nil {
+		t.Errorf("expected nil, got % x", response.Bytes())
+	}
+	holdingRegisters, _ := s.HoldingRegisters(1)
+	if holdingRegisters[1] != 0 {
+		t.Errorf("expected 0, got %v", holdingRegisters[1])
+	}
+
+	// Neither response is sent nor logged as sent: most recent first, the
+	// receive in listen only mode, entering listen only mode and the receive
+	// of Force Listen Only Mode.
+	if counters := s.DiagnosticCounters(1); counters.ServerNoResponses != 2 {
+		t.Errorf("expected 2 no responses, got %d", counters.ServerNoResponses)
+	}
+	expect := []byte{0xA0, 0x04, 0x80}
+	if got := s.diagnostics[1].events; !isEqual(expect, got) {
+		t.Errorf("expected % x, got % x", expect, got)
+	}
+
+	// Restart Communications Option leaves listen only mode without a response.
+	if response := handleRTU(s, 8, 0x00, 0x01, 0x00, 0x00); response != nil {
+		t.Errorf("expected nil, got % x", response.Bytes())
+	}
+	response := handleRTU(s, 6, 0x00, 0x01, 0x00, 0x03)
+	if response == nil || GetException(response) != Success {
+		t.Fatalf("expected Success, got %v", response)
+	}
+}
+
+// Functions 11 and 12
+func TestCommEventCounterAndLog(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	handleRTU(s, 6, 0x00, 0x01, 0x00, 0x03)
+	handleRTU(s, 3, 0xFF, 0xFF, 0x00, 0x02)
+
+	// Only the successful write is counted.
+	response := handleRTU(s, 11)
+	expect := []byte{0x00, 0x00, 0x00, 0x01}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// Most recent first: receive and send of function 11, send with read
+	// exception and receive of function 3, send and receive of function 6,
+	// and the receive of this request.
+	response = handleRTU(s, 12)
+	expect = []byte{13, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+		0x80, 0x40, 0x80, 0x41, 0x80, 0x40, 0x80}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+// Function 17
+func TestReportServerId(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	response := handleRTU(s, 17)
+	expect := []byte{2, 0x01, 0xFF}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	s.SetServerId(1, []byte("mb"), []byte{0x01, 0x02})
+	response = handleRTU(s, 17)
+	expect = []byte{5, 'm', 'b', 0xFF, 0x01, 0x02}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestAcceptSerialRequestsBusCounters(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	request := rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03)
+	broken := CopyBytes(request)
+	broken[7]++
+	port := &delayedReadWriter{chunks: []delayedChunk{
+		{0, broken},
+		{100 * time.Millisecond, request},
+	}}
+
+	s.acceptSerialRequests(port, newRTUReader(port, rtuSilentInterval(1200), nil), newRTUFramer)
+
+	expect := DiagnosticCounters{BusMessages: 2, BusCommunicationErrors: 1, ServerMessages: 1}
+	var got DiagnosticCounters
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if got = s.DiagnosticCounters(1); got.ServerMessages > 0 {
+			break
+		}
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+}
+
+func TestCountBusMessage(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(2))
+	for _, commError := range []bool{false, true, false} {
+		s.countBusMessage(commError)
+	}
+	// Clear Counters and Diagnostic Register clears the bus counters of slave 1 only.
+	handleRTU(s, 8, 0x00, 0x0A, 0x00, 0x00)
+	s.countBusMessage(false)
+
+	for _, tt := range []struct {
+		id                     uint8
+		busMessages, busErrors uint16
+	}{{1, 1, 0}, {2, 4, 1}} {
+		got := s.DiagnosticCounters(tt.id)
+		if got.BusMessages != tt.busMessages || got.BusCommunicationErrors != tt.busErrors {
+			t.Errorf("slave %d: expected %d messages and %d errors, got %+v", tt.id, tt.busMessages, tt.busErrors, got)
+		}
+	}
+}
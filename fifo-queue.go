@@ -0,0 +1,130 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fifoQueueMaxCount is the most registers Read FIFO Queue returns.
+const fifoQueueMaxCount = 31
+
+var _ FIFOQueuer = new(memoryFIFOQueueUint8)
+
+type memoryFIFOQueueUint8 struct {
+	slaveNum  uint8
+	slaveLock []sync.Mutex
+	queues    []map[uint16][]uint16
+}
+
+// will create FIFO queues for slaveNum slaves, slave id is [1, slaveNum], slaveNumMax is 255, slaveNumMin is 1, every FIFO pointer address has its own queue
+func NewMemoryFIFOQueueUint8(slaveNum uint8) (fifoQueuer FIFOQueuer) {
+
+	if slaveNum < 1 {
+		slaveNum = 1
+	}
+	var s = &memoryFIFOQueueUint8{
+		slaveNum:  slaveNum,
+		slaveLock: make([]sync.Mutex, slaveNum),
+		queues:    make([]map[uint16][]uint16, slaveNum),
+	}
+	for i := range s.queues {
+		s.queues[i] = make(map[uint16][]uint16)
+	}
+	fifoQueuer = s
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memoryFIFOQueueUint8) PushFIFOQueue(id uint8, pointer uint16, values ...uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	defer s.slaveLock[id].Unlock()
+	var queue = s.queues[id][pointer]
+	if len(queue)+len(values) > fifoQueueMaxCount {
+		return errors.Errorf("fifo queue %d of slave %d is full, %d registers queued, %d pushed", pointer, id+1, len(queue), len(values))
+	}
+	s.queues[id][pointer] = append(queue, values...)
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memoryFIFOQueueUint8) FIFOQueue(id uint8, pointer uint16) (values []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	values = append([]uint16{}, s.queues[id][pointer]...)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memoryFIFOQueueUint8) PopFIFOQueue(id uint8, pointer uint16, count int) (values []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	defer s.slaveLock[id].Unlock()
+	var queue = s.queues[id][pointer]
+	if count > len(queue) {
+		count = len(queue)
+	}
+	if count < 0 {
+		count = 0
+	}
+	values = append([]uint16{}, queue[:count]...)
+	if count == len(queue) {
+		delete(s.queues[id], pointer)
+	} else {
+		s.queues[id][pointer] = append([]uint16{}, queue[count:]...)
+	}
+	return
+}
+
+func (s *memoryFIFOQueueUint8) getRealId(id uint8) (realId uint8) {
+
+	switch {
+	case id > s.slaveNum:
+		realId = s.slaveNum - 1
+	case id < 1:
+		realId = 0
+	default:
+		realId = id - 1
+	}
+	return
+}
+
+// PushFIFOQueue appends values to FIFO queue pointer of slave id, see FIFOQueuer.
+func (s *Server) PushFIFOQueue(id uint8, pointer uint16, values ...uint16) error {
+	if s.FIFOQueuer == nil {
+		return errors.New("server has no FIFOQueuer")
+	}
+	return s.FIFOQueuer.PushFIFOQueue(id, pointer, values...)
+}
+
+// ReadFIFOQueue function 24, reads the registers of a FIFO queue. As the
+// specification says, the queue is not cleared by the read, application code
+// removes the registers the master has got with PopFIFOQueue.
+func ReadFIFOQueue(s *Server, frame Framer) ([]byte, *Exception) {
+	if s.FIFOQueuer == nil {
+		return []byte{}, &IllegalFunction
+	}
+	data := frame.GetData()
+	if len(data) != 2 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	values, err := s.FIFOQueuer.FIFOQueue(frame.Addr(), binary.BigEndian.Uint16(data))
+	if err != nil {
+		return []byte{}, slaveException("read slave fifo queue", err)
+	}
+	if len(values) > fifoQueueMaxCount {
+		return []byte{}, &IllegalDataValue
+	}
+
+	response := make([]byte, 4, 4+len(values)*2)
+	binary.BigEndian.PutUint16(response[0:2], uint16(2+len(values)*2))
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(values)))
+	return append(response, Uint16ToBytes(values)...), &Success
+}
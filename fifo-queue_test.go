@@ -0,0 +1,104 @@
+package mbserver
+
+import (
+	"testing"
+)
+
+// Function 24
+func TestReadFIFOQueue(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	// No FIFO queues configured.
+	exception := GetException(handleRTU(s, 24, 0x04, 0xDE))
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+
+	s.FIFOQueuer = NewMemoryFIFOQueueUint8(1)
+	if err := s.PushFIFOQueue(1, 0x04DE, 0x01B8, 0x1284); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	response := handleRTU(s, 24, 0x04, 0xDE)
+	exception = GetException(response)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x00, 0x06, 0x00, 0x02, 0x01, 0xB8, 0x12, 0x84}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// The read does not clear the queue.
+	got = handleRTU(s, 24, 0x04, 0xDE).GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	// An empty queue.
+	response = handleRTU(s, 24, 0x00, 0x01)
+	expect = []byte{0x00, 0x02, 0x00, 0x00}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	exception = GetException(handleRTU(s, 24, 0x04))
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+}
+
+func TestReadFIFOQueueTooLong(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	s.FIFOQueuer = &fullFIFOQueue{NewMemoryFIFOQueueUint8(1)}
+
+	exception := GetException(handleRTU(s, 24, 0x00, 0x00))
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+}
+
+// fullFIFOQueue holds more registers than Read FIFO Queue can return.
+type fullFIFOQueue struct {
+	FIFOQueuer
+}
+
+func (q *fullFIFOQueue) FIFOQueue(id uint8, pointer uint16) ([]uint16, error) {
+	return make([]uint16, fifoQueueMaxCount+1), nil
+}
+
+func Test_memoryFIFOQueueUint8(t *testing.T) {
+	q := NewMemoryFIFOQueueUint8(1)
+	values := make([]uint16, fifoQueueMaxCount)
+	for i := range values {
+		values[i] = uint16(i)
+	}
+	if err := q.PushFIFOQueue(1, 0, values...); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err := q.PushFIFOQueue(1, 0, 31); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+
+	got, _ := q.PopFIFOQueue(1, 0, 2)
+	if !isEqual([]uint16{0, 1}, got) {
+		t.Errorf("expected %v, got %v", []uint16{0, 1}, got)
+	}
+	if err := q.PushFIFOQueue(1, 0, 31); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+	got, _ = q.FIFOQueue(1, 0)
+	if len(got) != fifoQueueMaxCount-1 || got[0] != 2 || got[len(got)-1] != 31 {
+		t.Errorf("expected 2 ... 31, got %v", got)
+	}
+
+	got, _ = q.PopFIFOQueue(1, 0, 100)
+	if len(got) != fifoQueueMaxCount-1 {
+		t.Errorf("expected %v registers, got %v", fifoQueueMaxCount-1, len(got))
+	}
+	if got, _ = q.FIFOQueue(1, 0); len(got) != 0 {
+		t.Errorf("expected empty queue, got %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+package mbserver
+
+import (
+	"fmt"
+	"sync"
+)
+
+var _ FileRecorder = new(localFileRecordUint8)
+
+type localFileRecordUint8 struct {
+	slaveNum     uint8
+	slaveLock    []sync.RWMutex
+	fileStoreDir string
+}
+
+// will create file records for slaveNum slaves, slave id is [1, slaveNum], slaveNumMax is 255, slaveNumMin is 1, the records of file n of slave id are stored in ${fileStoreDir}/${id}-file-${n}; if fileStoreDir is "", will use "./file-slave"
+func NewLocalFileRecordUint8(slaveNum uint8, fileStoreDir string) (fileRecorder FileRecorder) {
+
+	if slaveNum < 1 {
+		slaveNum = 1
+	}
+	if fileStoreDir == "" {
+		fileStoreDir = "./file-slave"
+	}
+	fileRecorder = &localFileRecordUint8{
+		slaveNum:     slaveNum,
+		slaveLock:    make([]sync.RWMutex, slaveNum),
+		fileStoreDir: fileStoreDir,
+	}
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *localFileRecordUint8) ReadFileRecords(id uint8, file uint16, record uint16, length uint16) (values []uint16, err error) {
+
+	id = s.getRealId(id)
+	var bsFileContent []byte
+	s.slaveLock[id].RLock()
+	bsFileContent, err = localStorageFileRead(s.filePath(id, file))
+	s.slaveLock[id].RUnlock()
+	if err == nil {
+		values = make([]uint16, length)
+		if records := BytesToUint16(bsFileContent); int(record) < len(records) {
+			copy(values, records[record:])
+		}
+	}
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *localFileRecordUint8) WriteFileRecords(id uint8, file uint16, record uint16, values []uint16) (err error) {
+
+	id = s.getRealId(id)
+	var filePath = s.filePath(id, file)
+	var bsFileContent []byte
+	s.slaveLock[id].Lock()
+	defer s.slaveLock[id].Unlock()
+	if bsFileContent, err = localStorageFileRead(filePath); err != nil {
+		return
+	}
+	var records = BytesToUint16(bsFileContent)
+	if end := int(record) + len(values); len(records) < end {
+		var newRecords = make([]uint16, end)
+		copy(newRecords, records)
+		records = newRecords
+	}
+	copy(records[record:], values)
+	_, err = localStorageWrite(s.fileStoreDir, filePath, Uint16ToBytes(records))
+	return
+}
+
+func (s *localFileRecordUint8) filePath(realId uint8, file uint16) string {
+	return fmt.Sprintf("%s/%d-file-%d", s.fileStoreDir, realId+1, file)
+}
+
+func (s *localFileRecordUint8) getRealId(id uint8) (realId uint8) {
+
+	switch {
+	case id > s.slaveNum:
+		realId = s.slaveNum - 1
+	case id < 1:
+		realId = 0
+	default:
+		realId = id - 1
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package mbserver
+
+import (
+	"testing"
+)
+
+func Test_localFileRecordUint8(t *testing.T) {
+	dir := t.TempDir()
+	fileRecorder := NewLocalFileRecordUint8(2, dir)
+
+	values, err := fileRecorder.ReadFileRecords(2, 1, 5, 2)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual([]uint16{0, 0}, values) {
+		t.Errorf("expected %v, got %v", []uint16{0, 0}, values)
+	}
+
+	if err = fileRecorder.WriteFileRecords(2, 1, 6, []uint16{0x1234, 0x5678}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if err = fileRecorder.WriteFileRecords(2, 1, 1, []uint16{0xABCD}); err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+
+	// A new store reads the records back from the files.
+	values, err = NewLocalFileRecordUint8(2, dir).ReadFileRecords(2, 1, 0, 9)
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	expect := []uint16{0, 0xABCD, 0, 0, 0, 0, 0x1234, 0x5678, 0}
+	if !isEqual(expect, values) {
+		t.Errorf("expected %v, got %v", expect, values)
+	}
+
+	values, _ = fileRecorder.ReadFileRecords(1, 1, 0, 2)
+	if !isEqual([]uint16{0, 0}, values) {
+		t.Errorf("expected %v, got %v", []uint16{0, 0}, values)
+	}
+}
@@ -0,0 +1,180 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+const (
+	// fileRecordNum is the number of records of a file.
+	fileRecordNum = 10000
+	// fileRecordReferenceType is the only reference type of file record sub-requests.
+	fileRecordReferenceType = 6
+)
+
+var _ FileRecorder = new(memoryFileRecordUint8)
+
+type memoryFileRecordUint8 struct {
+	slaveNum  uint8
+	slaveLock []sync.RWMutex
+	files     []map[uint16][]uint16
+}
+
+// will create file records for slaveNum slaves, slave id is [1, slaveNum], slaveNumMax is 255, slaveNumMin is 1, files are allocated when first written
+func NewMemoryFileRecordUint8(slaveNum uint8) (fileRecorder FileRecorder) {
+
+	if slaveNum < 1 {
+		slaveNum = 1
+	}
+	var s = &memoryFileRecordUint8{
+		slaveNum:  slaveNum,
+		slaveLock: make([]sync.RWMutex, slaveNum),
+		files:     make([]map[uint16][]uint16, slaveNum),
+	}
+	for i := range s.files {
+		s.files[i] = make(map[uint16][]uint16)
+	}
+	fileRecorder = s
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memoryFileRecordUint8) ReadFileRecords(id uint8, file uint16, record uint16, length uint16) (values []uint16, err error) {
+
+	id = s.getRealId(id)
+	values = make([]uint16, length)
+	s.slaveLock[id].RLock()
+	if records, ok := s.files[id][file]; ok {
+		copy(values, records[record:])
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memoryFileRecordUint8) WriteFileRecords(id uint8, file uint16, record uint16, values []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	records, ok := s.files[id][file]
+	if !ok {
+		records = make([]uint16, fileRecordNum)
+		s.files[id][file] = records
+	}
+	copy(records[record:], values)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+func (s *memoryFileRecordUint8) getRealId(id uint8) (realId uint8) {
+
+	switch {
+	case id > s.slaveNum:
+		realId = s.slaveNum - 1
+	case id < 1:
+		realId = 0
+	default:
+		realId = id - 1
+	}
+	return
+}
+
+// fileRecordRequest is one sub-request of function 20 or 21.
+type fileRecordRequest struct {
+	file   uint16
+	record uint16
+	length uint16
+	values []byte
+}
+
+// checkFileRecordRequest checks the reference type and that the records exist.
+func checkFileRecordRequest(data []byte) (request fileRecordRequest, exception *Exception) {
+	request = fileRecordRequest{
+		file:   binary.BigEndian.Uint16(data[1:3]),
+		record: binary.BigEndian.Uint16(data[3:5]),
+		length: binary.BigEndian.Uint16(data[5:7]),
+	}
+	if request.length == 0 {
+		return request, &IllegalDataValue
+	}
+	if data[0] != fileRecordReferenceType || request.file == 0 || int(request.record)+int(request.length) > fileRecordNum {
+		return request, &IllegalDataAddress
+	}
+	return request, &Success
+}
+
+// ReadFileRecord function 20, reads groups of records from the slave's files.
+func ReadFileRecord(s *Server, frame Framer) ([]byte, *Exception) {
+	if s.FileRecorder == nil {
+		return []byte{}, &IllegalFunction
+	}
+	data := frame.GetData()
+	if len(data) < 1 || data[0] < 0x07 || data[0] > 0xF5 || int(data[0]) != len(data)-1 || data[0]%7 != 0 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	var requests []fileRecordRequest
+	responseLength := 0
+	for i := 1; i < len(data); i += 7 {
+		request, exception := checkFileRecordRequest(data[i : i+7])
+		if exception != &Success {
+			return []byte{}, exception
+		}
+		requests = append(requests, request)
+		responseLength += 2 + int(request.length)*2
+	}
+	// The response PDU holds the function code, the response data length and the groups.
+	if 2+responseLength > 253 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	response := make([]byte, 1, 1+responseLength)
+	response[0] = byte(responseLength)
+	for _, request := range requests {
+		values, err := s.FileRecorder.ReadFileRecords(frame.Addr(), request.file, request.record, request.length)
+		if err != nil {
+			return []byte{}, slaveException("read slave file records", err)
+		}
+		response = append(response, byte(1+len(values)*2), fileRecordReferenceType)
+		response = append(response, Uint16ToBytes(values)...)
+	}
+	return response, &Success
+}
+
+// WriteFileRecord function 21, writes groups of records to the slave's files.
+func WriteFileRecord(s *Server, frame Framer) ([]byte, *Exception) {
+	if s.FileRecorder == nil {
+		return []byte{}, &IllegalFunction
+	}
+	data := frame.GetData()
+	if len(data) < 1 || data[0] < 0x09 || data[0] > 0xFB || int(data[0]) != len(data)-1 {
+		return []byte{}, &IllegalDataValue
+	}
+
+	// Check every group before writing any of them.
+	var requests []fileRecordRequest
+	for i := 1; i < len(data); {
+		if len(data)-i < 7 {
+			return []byte{}, &IllegalDataValue
+		}
+		request, exception := checkFileRecordRequest(data[i : i+7])
+		if exception != &Success {
+			return []byte{}, exception
+		}
+		i += 7
+		if len(data)-i < int(request.length)*2 {
+			return []byte{}, &IllegalDataValue
+		}
+		request.values = data[i : i+int(request.length)*2]
+		i += len(request.values)
+		requests = append(requests, request)
+	}
+
+	for _, request := range requests {
+		err := s.FileRecorder.WriteFileRecords(frame.Addr(), request.file, request.record, BytesToUint16(request.values))
+		if err != nil {
+			return []byte{}, slaveException("write slave file records", err)
+		}
+	}
+	return data, &Success
+}
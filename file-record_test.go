@@ -0,0 +1,89 @@
+package mbserver
+
+import (
+	"testing"
+)
+
+// Function 21 then function 20
+func TestFileRecord(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	s.FileRecorder = NewMemoryFileRecordUint8(1)
+
+	// Write records 7 and 8 of file 4 and record 9999 of file 3.
+	request := []byte{0x14,
+		0x06, 0x00, 0x04, 0x00, 0x07, 0x00, 0x02, 0x06, 0xAF, 0x04, 0xBE,
+		0x06, 0x00, 0x03, 0x27, 0x0F, 0x00, 0x01, 0x10, 0x0D,
+	}
+	response := handleRTU(s, 21, request...)
+	exception := GetException(response)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	got := response.GetData()
+	if !isEqual(request, got) {
+		t.Errorf("expected %v, got %v", request, got)
+	}
+
+	response = handleRTU(s, 20, 0x0E,
+		0x06, 0x00, 0x04, 0x00, 0x06, 0x00, 0x03,
+		0x06, 0x00, 0x03, 0x27, 0x0F, 0x00, 0x01,
+	)
+	exception = GetException(response)
+	if exception != Success {
+		t.Fatalf("expected Success, got %v", exception.String())
+	}
+	expect := []byte{0x0C,
+		0x07, 0x06, 0x00, 0x00, 0x06, 0xAF, 0x04, 0xBE,
+		0x03, 0x06, 0x10, 0x0D,
+	}
+	got = response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestFileRecordErrors(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	// No file records configured.
+	exception := GetException(handleRTU(s, 20, 0x07, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01))
+	if exception != IllegalFunction {
+		t.Errorf("expected IllegalFunction, got %v", exception.String())
+	}
+
+	s.FileRecorder = NewMemoryFileRecordUint8(1)
+	tests := []struct {
+		name      string
+		function  uint8
+		data      []byte
+		exception Exception
+	}{
+		{"byte count", 20, []byte{0x08, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, IllegalDataValue},
+		{"reference type", 20, []byte{0x07, 0x07, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		{"file 0", 20, []byte{0x07, 0x06, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		{"record 10000", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x27, 0x10, 0x00, 0x01}, IllegalDataAddress},
+		{"past the last record", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x27, 0x0F, 0x00, 0x02}, IllegalDataAddress},
+		{"response too long", 20, []byte{0x07, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x7E}, IllegalDataValue},
+		{"write short of values", 21, []byte{0x09, 0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01}, IllegalDataValue},
+		{"write reference type", 21, []byte{0x09, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x01}, IllegalDataAddress},
+	}
+	for _, test := range tests {
+		exception := GetException(handleRTU(s, test.function, test.data...))
+		if exception != test.exception {
+			t.Errorf("%s: expected %v, got %v", test.name, test.exception.String(), exception.String())
+		}
+	}
+
+	// A bad second group leaves the first one unwritten.
+	exception = GetException(handleRTU(s, 21, 0x12,
+		0x06, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x12, 0x34,
+		0x06, 0x00, 0x01, 0x27, 0x10, 0x00, 0x01, 0x56, 0x78,
+	))
+	if exception != IllegalDataAddress {
+		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+	}
+	values, _ := s.FileRecorder.ReadFileRecords(1, 1, 0, 1)
+	if values[0] != 0 {
+		t.Errorf("expected 0, got %v", values[0])
+	}
+}
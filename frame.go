@@ -13,6 +13,37 @@ type Framer interface {
 	SetData(data []byte)
 }
 
+// frameOrigin returns the origin of the request of frame, who the changes of
+// its handler are recorded as made by, see SlaveOriginUpdater.
+func frameOrigin(frame Framer) string {
+	switch frame := frame.(type) {
+	case *TCPFrame:
+		return frame.origin
+	case *RTUFrame:
+		return frame.origin
+	case *ASCIIFrame:
+		return frame.origin
+	}
+	return ""
+}
+
+// setFrameOrigin sets the origin of the request of frame, see frameOrigin.
+func setFrameOrigin(frame Framer, origin string) {
+	switch frame := frame.(type) {
+	case *TCPFrame:
+		frame.origin = origin
+	case *RTUFrame:
+		frame.origin = origin
+	case *ASCIIFrame:
+		frame.origin = origin
+	}
+}
+
+// packetReader splits a byte stream into the packets of one Modbus frame each.
+type packetReader interface {
+	ReadPacket() ([]byte, error)
+}
+
 // GetException retunrns the Modbus exception or Success (indicating not exception).
 func GetException(frame Framer) (exception Exception) {
 	function := frame.GetFunction()
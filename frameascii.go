@@ -0,0 +1,157 @@
+package mbserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// asciiMaxLength is the largest ASCII ADU: ':', hex encoded address, 253 byte
+// PDU and LRC, then CR LF.
+const asciiMaxLength = 513
+
+// ASCIIFrame is the Modbus ASCII frame.
+type ASCIIFrame struct {
+	Address  uint8
+	Function uint8
+	Data     []byte
+	LRC      uint8
+	// origin is the origin of a request, see frameOrigin.
+	origin string
+}
+
+// NewASCIIFrame converts a packet to a Modbus ASCII frame. The packet starts
+// with ':' and ends with CR LF, the hex digits may be upper or lower case.
+func NewASCIIFrame(packet []byte) (*ASCIIFrame, error) {
+	pLen := len(packet)
+	if pLen < 9 {
+		return nil, errors.Errorf("ASCII Frame error: packet less than 9 bytes: %q", packet)
+	}
+	if packet[0] != ':' || packet[pLen-2] != '\r' || packet[pLen-1] != '\n' {
+		return nil, errors.Errorf("ASCII Frame error: missing ':' or CR LF: %q", packet)
+	}
+
+	data := make([]byte, hex.DecodedLen(pLen-3))
+	if _, err := hex.Decode(data, packet[1:pLen-2]); err != nil {
+		return nil, errors.Wrapf(err, "ASCII Frame error: bad hex digits %q", packet)
+	}
+
+	// Check the LRC.
+	dLen := len(data)
+	lrcExpect := data[dLen-1]
+	lrcCalc := lrcModbus(data[0 : dLen-1])
+	if lrcCalc != lrcExpect {
+		return nil, errors.Errorf("ASCII Frame error: LRC (expected 0x%02x, got 0x%02x)", lrcExpect, lrcCalc)
+	}
+
+	frame := &ASCIIFrame{
+		Address:  data[0],
+		Function: data[1],
+		Data:     data[2 : dLen-1],
+		LRC:      lrcExpect,
+	}
+
+	return frame, nil
+}
+
+// Copy the ASCIIFrame.
+func (frame *ASCIIFrame) Copy() Framer {
+	copy := *frame
+	return &copy
+}
+
+// Bytes returns the Modbus byte stream based on the ASCIIFrame fields
+func (frame *ASCIIFrame) Bytes() []byte {
+	data := make([]byte, 2, 3+len(frame.Data))
+
+	data[0] = frame.Address
+	data[1] = frame.Function
+	data = append(data, frame.Data...)
+
+	// Add the LRC.
+	data = append(data, lrcModbus(data))
+
+	bytes := make([]byte, 1+hex.EncodedLen(len(data))+2)
+	bytes[0] = ':'
+	hex.Encode(bytes[1:], data)
+	// The ASCII transmission mode uses upper case hex digits.
+	for i, c := range bytes {
+		if c >= 'a' && c <= 'f' {
+			bytes[i] = c - 'a' + 'A'
+		}
+	}
+	copy(bytes[len(bytes)-2:], "\r\n")
+
+	return bytes
+}
+
+// GetFunction returns the Modbus function code.
+func (frame *ASCIIFrame) GetFunction() uint8 {
+	return frame.Function
+}
+
+// GetData returns the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) GetData() []byte {
+	return frame.Data
+}
+
+// SetData sets the ASCIIFrame Data byte field.
+func (frame *ASCIIFrame) SetData(data []byte) {
+	frame.Data = data
+}
+
+// SetException sets the Modbus exception code in the frame.
+func (frame *ASCIIFrame) SetException(exception *Exception) {
+	frame.Function = frame.Function | 0x80
+	frame.Data = []byte{byte(*exception)}
+}
+
+func (frame *ASCIIFrame) Addr() uint8 {
+	return frame.Address
+}
+
+// asciiReader splits a byte stream into ASCII frames. A ':' always starts a
+// new frame, so the tail of an interrupted frame is dropped with it.
+type asciiReader struct {
+	r         *bufio.Reader
+	delimiter func() byte
+}
+
+// newASCIIReader reads frames that end with the character returned by
+// delimiter, LF if delimiter is nil.
+func newASCIIReader(r io.Reader, delimiter func() byte) *asciiReader {
+	return &asciiReader{r: bufio.NewReaderSize(r, asciiMaxLength), delimiter: delimiter}
+}
+
+// ReadPacket returns the next frame from ':' up to and including the
+// delimiter, which is replaced by LF.
+func (r *asciiReader) ReadPacket() (packet []byte, err error) {
+	delimiter := byte('\n')
+	if r.delimiter != nil {
+		delimiter = r.delimiter()
+	}
+
+	line, err := r.r.ReadSlice(delimiter)
+	if err == bufio.ErrBufferFull {
+		// Too long for a Modbus frame, drop everything up to the end of the line.
+		for err == bufio.ErrBufferFull {
+			_, err = r.r.ReadSlice(delimiter)
+		}
+		line = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if start := bytes.LastIndexByte(line, ':'); start > 0 {
+		line = line[start:]
+	}
+	packet = CopyBytes(line)
+	if len(packet) > 0 {
+		packet[len(packet)-1] = '\n'
+	}
+	return packet, nil
+}
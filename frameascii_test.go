@@ -0,0 +1,102 @@
+package mbserver
+
+import (
+	"bytes"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNewASCIIFrame(t *testing.T) {
+	frame, err := NewASCIIFrame([]byte(":1103006b00037e\r\n"))
+	if !isEqual(nil, err) {
+		t.Fatalf("expected %v, got %v", nil, err)
+	}
+
+	got := frame.Address
+	expect := 0x11
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	got = frame.Function
+	expect = 3
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	gotData := frame.Data
+	expectData := []byte{0x00, 0x6B, 0x00, 0x03}
+	if !isEqual(expectData, gotData) {
+		t.Errorf("expected %v, got %v", expectData, gotData)
+	}
+}
+
+func TestNewASCIIFrameBadLRC(t *testing.T) {
+	// Bad LRC: 0x7F (should be 0x7E)
+	_, err := NewASCIIFrame([]byte(":1103006B00037F\r\n"))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
+
+func TestNewASCIIFrameBadDelimiters(t *testing.T) {
+	for _, packet := range []string{"1103006B00037E\r\n", ":1103006B00037E", ":1103006B00037G\r\n", ":11\r\n"} {
+		_, err := NewASCIIFrame([]byte(packet))
+		if err == nil {
+			t.Errorf("%q: expected error not nil, got %v", packet, err)
+		}
+	}
+}
+
+func TestASCIIFrameBytes(t *testing.T) {
+	frame := &ASCIIFrame{
+		Address:  uint8(0x11),
+		Function: uint8(3),
+		Data:     []byte{0x00, 0x6b, 0x00, 0x03},
+	}
+
+	got := string(frame.Bytes())
+	expect := ":1103006B00037E\r\n"
+	if expect != got {
+		t.Errorf("expected %q, got %q", expect, got)
+	}
+}
+
+func TestASCIIReader(t *testing.T) {
+	// Line noise and an interrupted frame come before the two good frames.
+	stream := "\x00\xff:0106:1103006B00037E\r\n:010600010003F5\r\n"
+	reader := newASCIIReader(iotest.OneByteReader(bytes.NewReader([]byte(stream))), nil)
+
+	for _, expect := range []string{":1103006B00037E\r\n", ":010600010003F5\r\n"} {
+		got, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if expect != string(got) {
+			t.Errorf("expected %q, got %q", expect, got)
+		}
+	}
+}
+
+func TestAcceptSerialRequestsASCII(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	request := []byte(":010600010003F5\r\n")
+	port := &delayedReadWriter{chunks: []delayedChunk{
+		{0, request[:5]},
+		{time.Millisecond, request[5:]},
+	}}
+
+	s.acceptSerialRequests(port, newASCIIReader(port, s.asciiInputDelimiter), newASCIIFramer)
+
+	// The response to Write Single Register echoes the request.
+	var got []byte
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if got = port.Written(); len(got) >= len(request) {
+			break
+		}
+	}
+	if !isEqual(request, got) {
+		t.Errorf("expected %q, got %q", request, got)
+	}
+}
@@ -2,16 +2,24 @@ package mbserver
 
 import (
 	"encoding/binary"
+	"io"
+	"time"
 
+	"github.com/goburrow/serial"
 	"github.com/pkg/errors"
 )
 
+// rtuMaxLength is the largest RTU ADU: address, 253 byte PDU and CRC.
+const rtuMaxLength = 256
+
 // RTUFrame is the Modbus TCP frame.
 type RTUFrame struct {
 	Address  uint8
 	Function uint8
 	Data     []byte
 	CRC      uint16
+	// origin is the origin of a request, see frameOrigin.
+	origin string
 }
 
 // NewRTUFrame converts a packet to a Modbus TCP frame.
@@ -88,3 +96,160 @@ func (frame *RTUFrame) SetException(exception *Exception) {
 func (frame *RTUFrame) Addr() uint8 {
 	return frame.Address
 }
+
+// rtuSilentInterval returns the 3.5 character silent interval (t3.5) that
+// delimits RTU frames at the given baud rate. Above 19200 baud the spec fixes
+// it at 1.75ms.
+func rtuSilentInterval(baudRate int) time.Duration {
+	if baudRate <= 0 {
+		// 19200 is the serial package default.
+		baudRate = 19200
+	}
+	if baudRate > 19200 {
+		return 1750 * time.Microsecond
+	}
+	// One character is 11 bits: start bit, 8 data bits, parity and stop bit.
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baudRate))
+}
+
+// rtuRequestLength returns the length of the RTU request at the start of
+// packet, or 0 if it cannot be told from the bytes received so far.
+func rtuRequestLength(packet []byte) int {
+	if len(packet) < 2 {
+		return 0
+	}
+	switch packet[1] {
+	case 1, 2, 3, 4, 5, 6, 8:
+		return 8
+	case 7, 11, 12, 17:
+		return 4
+	case 15, 16:
+		if len(packet) > 6 {
+			return 9 + int(packet[6])
+		}
+	case 20, 21:
+		if len(packet) > 2 {
+			return 5 + int(packet[2])
+		}
+	case 22:
+		return 10
+	case 23:
+		if len(packet) > 10 {
+			return 13 + int(packet[10])
+		}
+	case 24:
+		return 6
+	case 43:
+		// Only Read Device Identification has a fixed length.
+		if len(packet) > 2 && packet[2] == 14 {
+			return 7
+		}
+	}
+	return 0
+}
+
+type rtuChunk struct {
+	data []byte
+	err  error
+}
+
+// rtuReader assembles RTU frames from a byte stream that may deliver them in
+// pieces. A frame is complete once the length implied by its function code
+// has arrived, or when the line has been silent for the t3.5 interval.
+type rtuReader struct {
+	silence time.Duration
+	done    <-chan struct{}
+	chunks  chan rtuChunk
+	buffer  []byte
+	err     error
+}
+
+// newRTUReader starts reading r in the background; done aborts a pending
+// ReadPacket, for example when the server is closed.
+func newRTUReader(r io.Reader, silence time.Duration, done <-chan struct{}) *rtuReader {
+	reader := &rtuReader{
+		silence: silence,
+		done:    done,
+		chunks:  make(chan rtuChunk),
+	}
+	go reader.read(r)
+	return reader
+}
+
+func (r *rtuReader) read(reader io.Reader) {
+	defer close(r.chunks)
+
+	for {
+		buffer := make([]byte, rtuMaxLength)
+		n, err := reader.Read(buffer)
+		// The serial port times out when the line is idle, that is not an error.
+		if err == serial.ErrTimeout {
+			err = nil
+		}
+		if n == 0 && err == nil {
+			continue
+		}
+		select {
+		case r.chunks <- rtuChunk{buffer[:n], err}:
+		case <-r.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ReadPacket returns the next RTU frame. The CRC is not checked, an incomplete
+// frame cut short by a silent interval is returned as is.
+func (r *rtuReader) ReadPacket() (packet []byte, err error) {
+	timer := time.NewTimer(r.silence)
+	defer timer.Stop()
+
+	for {
+		if length := rtuRequestLength(r.buffer); length > 0 && len(r.buffer) >= length {
+			return r.next(length), nil
+		}
+		if len(r.buffer) >= rtuMaxLength {
+			return r.next(rtuMaxLength), nil
+		}
+		if r.err != nil {
+			if len(r.buffer) > 0 {
+				return r.next(len(r.buffer)), nil
+			}
+			return nil, r.err
+		}
+
+		var silence <-chan time.Time
+		if len(r.buffer) > 0 {
+			silence = timer.C
+		}
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				r.err = io.EOF
+				continue
+			}
+			r.buffer = append(r.buffer, chunk.data...)
+			r.err = chunk.err
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(r.silence)
+		case <-silence:
+			return r.next(len(r.buffer)), nil
+		case <-r.done:
+			return nil, io.EOF
+		}
+	}
+}
+
+func (r *rtuReader) next(length int) (packet []byte) {
+	packet = make([]byte, length)
+	copy(packet, r.buffer)
+	r.buffer = r.buffer[length:]
+	return packet
+}
@@ -1,6 +1,14 @@
 package mbserver
 
-import "testing"
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goburrow/serial"
+)
 
 func TestNewRTUFrame(t *testing.T) {
 	frame, err := NewRTUFrame([]byte{0x01, 0x04, 0x02, 0xFF, 0xFF, 0xB8, 0x80})
@@ -49,3 +57,169 @@ func TestRTUFrameBytes(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+type delayedChunk struct {
+	delay time.Duration
+	data  []byte
+}
+
+// delayedReadWriter is an in-memory serial line that delivers each chunk
+// after its delay, then reports io.EOF.
+type delayedReadWriter struct {
+	mu      sync.Mutex
+	chunks  []delayedChunk
+	written bytes.Buffer
+}
+
+func (rw *delayedReadWriter) Read(b []byte) (int, error) {
+	rw.mu.Lock()
+	if len(rw.chunks) == 0 {
+		rw.mu.Unlock()
+		return 0, io.EOF
+	}
+	chunk := rw.chunks[0]
+	rw.chunks = rw.chunks[1:]
+	rw.mu.Unlock()
+
+	time.Sleep(chunk.delay)
+	return copy(b, chunk.data), nil
+}
+
+func (rw *delayedReadWriter) Write(b []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.written.Write(b)
+}
+
+func (rw *delayedReadWriter) Close() error { return nil }
+
+func (rw *delayedReadWriter) Open(*serial.Config) error { return nil }
+
+func (rw *delayedReadWriter) Written() []byte {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return CopyBytes(rw.written.Bytes())
+}
+
+func rtuPacket(address, function uint8, data ...byte) []byte {
+	frame := &RTUFrame{Address: address, Function: function, Data: data}
+	return frame.Bytes()
+}
+
+func readRTUPackets(t *testing.T, reader *rtuReader, count int) (packets [][]byte) {
+	for i := 0; i < count; i++ {
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		packets = append(packets, packet)
+	}
+	return packets
+}
+
+func TestRTUSilentInterval(t *testing.T) {
+	got := rtuSilentInterval(9600)
+	expect := 4010416 * time.Nanosecond
+	if got != expect {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	got = rtuSilentInterval(115200)
+	expect = 1750 * time.Microsecond
+	if got != expect {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRTUReaderSplitFrame(t *testing.T) {
+	packet := rtuPacket(1, 3, 0x00, 0x00, 0x00, 0x02)
+	rw := &delayedReadWriter{chunks: []delayedChunk{
+		{0, packet[0:1]},
+		{time.Millisecond, packet[1:3]},
+		{time.Millisecond, packet[3:7]},
+		{time.Millisecond, packet[7:]},
+	}}
+
+	reader := newRTUReader(rw, rtuSilentInterval(1200), nil)
+	got := readRTUPackets(t, reader, 1)
+	if !isEqual([][]byte{packet}, got) {
+		t.Errorf("expected %v, got %v", [][]byte{packet}, got)
+	}
+}
+
+func TestRTUReaderBackToBackFrames(t *testing.T) {
+	first := rtuPacket(1, 16, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x03, 0x00, 0x04)
+	second := rtuPacket(1, 3, 0x00, 0x01, 0x00, 0x02)
+	rw := &delayedReadWriter{chunks: []delayedChunk{
+		{0, append(CopyBytes(first), second[:3]...)},
+		{time.Millisecond, second[3:]},
+	}}
+
+	reader := newRTUReader(rw, rtuSilentInterval(1200), nil)
+	got := readRTUPackets(t, reader, 2)
+	expect := [][]byte{first, second}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRTUReaderSilentIntervalDelimits(t *testing.T) {
+	// The length of a user defined function code is only known from the silent interval.
+	first := rtuPacket(1, 65, 0x01, 0x02, 0x03)
+	second := rtuPacket(1, 65, 0x04)
+	rw := &delayedReadWriter{chunks: []delayedChunk{
+		{0, first[:2]},
+		{time.Millisecond, first[2:]},
+		{100 * time.Millisecond, second},
+	}}
+
+	reader := newRTUReader(rw, rtuSilentInterval(1200), nil)
+	got := readRTUPackets(t, reader, 2)
+	expect := [][]byte{first, second}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestRTUReaderIncompleteFrame(t *testing.T) {
+	broken := rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03)[:5]
+	packet := rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x04)
+	rw := &delayedReadWriter{chunks: []delayedChunk{
+		{0, broken},
+		{100 * time.Millisecond, packet},
+	}}
+
+	reader := newRTUReader(rw, rtuSilentInterval(1200), nil)
+	got := readRTUPackets(t, reader, 2)
+	expect := [][]byte{broken, packet}
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	_, err := reader.ReadPacket()
+	if err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestAcceptSerialRequestsSplitFrame(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	request := rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03)
+	port := &delayedReadWriter{chunks: []delayedChunk{
+		{0, request[:3]},
+		{time.Millisecond, request[3:]},
+	}}
+
+	s.acceptSerialRequests(port, newRTUReader(port, rtuSilentInterval(1200), nil), newRTUFramer)
+
+	// The response to Write Single Register echoes the request.
+	var got []byte
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if got = port.Written(); len(got) >= len(request) {
+			break
+		}
+	}
+	if !isEqual(request, got) {
+		t.Errorf("expected % x, got % x", request, got)
+	}
+}
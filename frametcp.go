@@ -1,11 +1,20 @@
 package mbserver
 
 import (
+	"bufio"
 	"encoding/binary"
+	"io"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// tcpHeaderLength is the length of the MBAP header, including the unit identifier.
+	tcpHeaderLength = 7
+	// tcpMaxLength is the largest MBAP Length field: unit identifier plus a 253 byte PDU.
+	tcpMaxLength = 254
+)
+
 // TCPFrame is the Modbus TCP frame.
 type TCPFrame struct {
 	TransactionIdentifier uint16
@@ -14,13 +23,15 @@ type TCPFrame struct {
 	Device                uint8
 	Function              uint8
 	Data                  []byte
+	// origin is the origin of a request, see frameOrigin.
+	origin string
 }
 
 // NewTCPFrame converts a packet to a Modbus TCP frame.
 func NewTCPFrame(packet []byte) (*TCPFrame, error) {
-	// Check if the packet is too short.
-	if len(packet) < 9 {
-		return nil, errors.New("TCP Frame error: packet less than 9 bytes")
+	// Check if the packet is too short, the PDU holds at least the function code.
+	if len(packet) < 8 {
+		return nil, errors.New("TCP Frame error: packet less than 8 bytes")
 	}
 
 	frame := &TCPFrame{
@@ -40,6 +51,46 @@ func NewTCPFrame(packet []byte) (*TCPFrame, error) {
 	return frame, nil
 }
 
+// readTCPPacket reads exactly one Modbus TCP ADU from a byte stream. The MBAP
+// Length field decides where the ADU ends, so requests split across several
+// reads are reassembled and back-to-back requests are returned one at a time.
+func readTCPPacket(r io.Reader) (packet []byte, err error) {
+	header := make([]byte, tcpHeaderLength)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	// The length counts the unit identifier and the PDU, which holds at least the function code.
+	length := int(binary.BigEndian.Uint16(header[4:6]))
+	if length < 2 || length > tcpMaxLength {
+		return nil, errors.Errorf("TCP Frame error: invalid MBAP length %d", length)
+	}
+
+	packet = make([]byte, tcpHeaderLength-1+length)
+	copy(packet, header)
+	if _, err = io.ReadFull(r, packet[tcpHeaderLength:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+// tcpReader splits a byte stream into Modbus TCP ADUs.
+type tcpReader struct {
+	r *bufio.Reader
+}
+
+func newTCPReader(r io.Reader) *tcpReader {
+	return &tcpReader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the next ADU.
+func (r *tcpReader) ReadPacket() ([]byte, error) {
+	return readTCPPacket(r.r)
+}
+
 // Copy the TCPFrame.
 func (frame *TCPFrame) Copy() Framer {
 	copy := *frame
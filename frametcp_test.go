@@ -0,0 +1,56 @@
+package mbserver
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReadTCPPacketSplit(t *testing.T) {
+	packet := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02}
+
+	// Deliver the ADU one byte at a time, including the MBAP header.
+	got, err := readTCPPacket(iotest.OneByteReader(bytes.NewReader(packet)))
+	if err != nil {
+		t.Fatalf("expected nil, got %v", err)
+	}
+	if !isEqual(packet, got) {
+		t.Errorf("expected %v, got %v", packet, got)
+	}
+}
+
+func TestReadTCPPacketPipelined(t *testing.T) {
+	first := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02}
+	second := []byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x01, 0x00, 0x0a, 0x00, 0x09}
+	stream := bytes.NewReader(append(append([]byte{}, first...), second...))
+
+	for _, expect := range [][]byte{first, second} {
+		got, err := readTCPPacket(stream)
+		if err != nil {
+			t.Fatalf("expected nil, got %v", err)
+		}
+		if !isEqual(expect, got) {
+			t.Errorf("expected %v, got %v", expect, got)
+		}
+	}
+
+	_, err := readTCPPacket(stream)
+	if err != io.EOF {
+		t.Errorf("expected %v, got %v", io.EOF, err)
+	}
+}
+
+func TestReadTCPPacketTruncated(t *testing.T) {
+	_, err := readTCPPacket(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03}))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestReadTCPPacketBadLength(t *testing.T) {
+	_, err := readTCPPacket(bytes.NewReader([]byte{0x00, 0x01, 0x00, 0x00, 0x01, 0x00, 0x01, 0x03}))
+	if err == nil {
+		t.Fatalf("expected error not nil, got %v", err)
+	}
+}
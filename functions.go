@@ -3,14 +3,16 @@ package mbserver
 import (
 	"encoding/binary"
 	"log"
+
+	"github.com/pkg/errors"
 )
 
 // ReadCoils function 1, reads coils from internal memory.
 func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
-	if endRegister > 65535 {
-		return []byte{}, &IllegalDataAddress
+	if exception := checkReadRequest(frame, maxReadBits); exception != &Success {
+		return []byte{}, exception
 	}
+	register, numRegs, _ := registerAddressAndNumber(frame)
 	dataSize := numRegs / 8
 	if (numRegs % 8) != 0 {
 		dataSize++
@@ -18,12 +20,11 @@ func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
 	data := make([]byte, 1+dataSize)
 	data[0] = byte(dataSize)
 
-	coils, err := s.Coils(frame.Addr())
+	coils, err := s.rangeSlaver().ReadCoils(frame.Addr(), uint16(register), uint16(numRegs))
 	if err != nil {
-		log.Printf("read slave coils fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("read slave coils", err)
 	}
-	for i, value := range coils[register:endRegister] {
+	for i, value := range coils {
 		if value != 0 {
 			shift := uint(i) % 8
 			data[1+i/8] |= byte(1 << shift)
@@ -34,10 +35,10 @@ func ReadCoils(s *Server, frame Framer) ([]byte, *Exception) {
 
 // ReadDiscreteInputs function 2, reads discrete inputs from internal memory.
 func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
-	if endRegister > 65535 {
-		return []byte{}, &IllegalDataAddress
+	if exception := checkReadRequest(frame, maxReadBits); exception != &Success {
+		return []byte{}, exception
 	}
+	register, numRegs, _ := registerAddressAndNumber(frame)
 	dataSize := numRegs / 8
 	if (numRegs % 8) != 0 {
 		dataSize++
@@ -45,12 +46,11 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
 	data := make([]byte, 1+dataSize)
 	data[0] = byte(dataSize)
 
-	discreteInputs, err := s.DiscreteInputs(frame.Addr())
+	discreteInputs, err := s.rangeSlaver().ReadDiscreteInputs(frame.Addr(), uint16(register), uint16(numRegs))
 	if err != nil {
-		log.Printf("read slave discreteInputs fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("read slave discreteInputs", err)
 	}
-	for i, value := range discreteInputs[register:endRegister] {
+	for i, value := range discreteInputs {
 		if value != 0 {
 			shift := uint(i) % 8
 			data[1+i/8] |= byte(1 << shift)
@@ -61,51 +61,52 @@ func ReadDiscreteInputs(s *Server, frame Framer) ([]byte, *Exception) {
 
 // ReadHoldingRegisters function 3, reads holding registers from internal memory.
 func ReadHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
-	if endRegister > 65536 {
-		return []byte{}, &IllegalDataAddress
+	if exception := checkReadRequest(frame, maxReadRegisters); exception != &Success {
+		return []byte{}, exception
 	}
+	register, numRegs, _ := registerAddressAndNumber(frame)
 
-	holdingRegisters, err := s.HoldingRegisters(frame.Addr())
+	holdingRegisters, err := s.rangeSlaver().ReadHoldingRegisters(frame.Addr(), uint16(register), uint16(numRegs))
 	if err != nil {
-		log.Printf("read slave holdingRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("read slave holdingRegisters", err)
 	}
-	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(holdingRegisters[register:endRegister])...), &Success
+	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(holdingRegisters)...), &Success
 }
 
 // ReadInputRegisters function 4, reads input registers from internal memory.
 func ReadInputRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
-	if endRegister > 65536 {
-		return []byte{}, &IllegalDataAddress
+	if exception := checkReadRequest(frame, maxReadRegisters); exception != &Success {
+		return []byte{}, exception
 	}
+	register, numRegs, _ := registerAddressAndNumber(frame)
 
-	inputRegisters, err := s.InputRegisters(frame.Addr())
+	inputRegisters, err := s.rangeSlaver().ReadInputRegisters(frame.Addr(), uint16(register), uint16(numRegs))
 	if err != nil {
-		log.Printf("read slave inputRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("read slave inputRegisters", err)
 	}
-	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(inputRegisters[register:endRegister])...), &Success
+	return append([]byte{byte(numRegs * 2)}, Uint16ToBytes(inputRegisters)...), &Success
 }
 
 // WriteSingleCoil function 5, write a coil to internal memory.
 func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 4 {
+		return []byte{}, &IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
-	// TODO Should we use 0 for off and 65,280 (FF00 in hexadecimal) for on?
-	if value != 0 {
+	// 0xFF00 is on and 0x0000 is off.
+	switch value {
+	case 0xFF00:
 		value = 1
+	case 0x0000:
+	default:
+		return []byte{}, &IllegalDataValue
 	}
 
-	coils, err := s.Coils(frame.Addr())
+	err := s.update(frame, func(tables SlaveTables) error {
+		return tables.WriteCoils(uint16(register), []byte{byte(value)})
+	})
 	if err != nil {
-		log.Printf("read slave coils fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
-	}
-	coils[register] = byte(value)
-	if err = s.SaveCoils(frame.Addr(), coils); err != nil {
-		log.Printf("write slave coils fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("write slave coils", err)
 	}
 
 	return frame.GetData()[0:4], &Success
@@ -113,17 +114,16 @@ func WriteSingleCoil(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteHoldingRegister function 6, write a holding register to internal memory.
 func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
+	if len(frame.GetData()) != 4 {
+		return []byte{}, &IllegalDataValue
+	}
 	register, value := registerAddressAndValue(frame)
 
-	holdingRegisters, err := s.HoldingRegisters(frame.Addr())
+	err := s.update(frame, func(tables SlaveTables) error {
+		return tables.WriteHoldingRegisters(uint16(register), []uint16{value})
+	})
 	if err != nil {
-		log.Printf("read slave holdingRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
-	}
-	holdingRegisters[register] = value
-	if err = s.SaveHoldingRegisters(frame.Addr(), holdingRegisters); err != nil {
-		log.Printf("write slave holdingRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("write slave holdingRegisters", err)
 	}
 
 	return frame.GetData()[0:4], &Success
@@ -131,40 +131,24 @@ func WriteHoldingRegister(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteMultipleCoils function 15, writes holding registers to internal memory.
 func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, endRegister := registerAddressAndNumber(frame)
-	valueBytes := frame.GetData()[5:]
-
-	if endRegister > 65536 {
-		return []byte{}, &IllegalDataAddress
+	if exception := checkWriteRequest(frame, maxWriteBits, true); exception != &Success {
+		return []byte{}, exception
 	}
+	register, numRegs, _ := registerAddressAndNumber(frame)
+	valueBytes := frame.GetData()[5:]
 
-	// TODO This is not correct, bits and bytes do not always align
-	//if len(valueBytes)/2 != numRegs {
-	//	return []byte{}, &IllegalDataAddress
-	//}
-
-	coils, err := s.Coils(frame.Addr())
-	if err != nil {
-		log.Printf("read slave coils fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
-	}
-	bitCount := 0
+	coils := make([]byte, 0, numRegs)
 	for i, value := range valueBytes {
-		for bitPos := uint(0); bitPos < 8; bitPos++ {
-			coils[register+(i*8)+int(bitPos)] = bitAtPosition(value, bitPos)
-			bitCount++
-			if bitCount >= numRegs {
-				break
-			}
-		}
-		if bitCount >= numRegs {
-			break
+		for bitPos := uint(0); bitPos < 8 && i*8+int(bitPos) < numRegs; bitPos++ {
+			coils = append(coils, bitAtPosition(value, bitPos))
 		}
 	}
 
-	if err = s.SaveCoils(frame.Addr(), coils); err != nil {
-		log.Printf("write slave coils fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+	err := s.update(frame, func(tables SlaveTables) error {
+		return tables.WriteCoils(uint16(register), coils)
+	})
+	if err != nil {
+		return []byte{}, slaveException("write slave coils", err)
 	}
 
 	return frame.GetData()[0:4], &Success
@@ -172,37 +156,145 @@ func WriteMultipleCoils(s *Server, frame Framer) ([]byte, *Exception) {
 
 // WriteHoldingRegisters function 16, writes holding registers to internal memory.
 func WriteHoldingRegisters(s *Server, frame Framer) ([]byte, *Exception) {
-	register, numRegs, _ := registerAddressAndNumber(frame)
+	if exception := checkWriteRequest(frame, maxWriteRegisters, false); exception != &Success {
+		return []byte{}, exception
+	}
+	register, _, _ := registerAddressAndNumber(frame)
 	valueBytes := frame.GetData()[5:]
-	var exception *Exception
-	var data []byte
 
-	if len(valueBytes)/2 != numRegs {
-		exception = &IllegalDataAddress
+	// Copy data to memroy
+	err := s.update(frame, func(tables SlaveTables) error {
+		return tables.WriteHoldingRegisters(uint16(register), BytesToUint16(valueBytes))
+	})
+	if err != nil {
+		return []byte{}, slaveException("write slave holdingRegisters", err)
 	}
 
-	holdingRegisters, err := s.HoldingRegisters(frame.Addr())
+	return frame.GetData()[0:4], &Success
+}
+
+// MaskWriteRegister function 22, modifies a holding register with an AND mask and an OR mask.
+func MaskWriteRegister(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) != 6 {
+		return []byte{}, &IllegalDataValue
+	}
+	register := binary.BigEndian.Uint16(data[0:2])
+	andMask := binary.BigEndian.Uint16(data[2:4])
+	orMask := binary.BigEndian.Uint16(data[4:6])
+
+	err := s.update(frame, func(tables SlaveTables) error {
+		holdingRegisters, err := tables.ReadHoldingRegisters(register, 1)
+		if err != nil {
+			return err
+		}
+		return tables.WriteHoldingRegisters(register, []uint16{(holdingRegisters[0] & andMask) | (orMask &^ andMask)})
+	})
 	if err != nil {
-		log.Printf("read slave holdingRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+		return []byte{}, slaveException("update slave holdingRegisters", err)
 	}
 
-	// Copy data to memroy
+	return data[0:6], &Success
+}
+
+// ReadWriteMultipleRegisters function 23, writes holding registers and then reads holding registers in one operation.
+func ReadWriteMultipleRegisters(s *Server, frame Framer) ([]byte, *Exception) {
+	data := frame.GetData()
+	if len(data) < 9 {
+		return []byte{}, &IllegalDataValue
+	}
+	readRegister := int(binary.BigEndian.Uint16(data[0:2]))
+	numReadRegs := int(binary.BigEndian.Uint16(data[2:4]))
+	writeRegister := int(binary.BigEndian.Uint16(data[4:6]))
+	numWriteRegs := int(binary.BigEndian.Uint16(data[6:8]))
+	valueBytes := data[9:]
+
+	if numReadRegs < 1 || numReadRegs > maxReadRegisters || numWriteRegs < 1 || numWriteRegs > maxReadWriteRegisters ||
+		int(data[8]) != numWriteRegs*2 || len(valueBytes) != numWriteRegs*2 {
+		return []byte{}, &IllegalDataValue
+	}
+	if readRegister+numReadRegs > 65536 || writeRegister+numWriteRegs > 65536 {
+		return []byte{}, &IllegalDataAddress
+	}
+
+	// The write is performed before the read.
 	values := BytesToUint16(valueBytes)
-	valuesUpdated := copy(holdingRegisters[register:], values)
-	if valuesUpdated == numRegs {
-		exception = &Success
-		data = frame.GetData()[0:4]
-	} else {
-		exception = &IllegalDataAddress
+	var result []byte
+	err := s.update(frame, func(tables SlaveTables) error {
+		if err := tables.WriteHoldingRegisters(uint16(writeRegister), values); err != nil {
+			return err
+		}
+		holdingRegisters, err := tables.ReadHoldingRegisters(uint16(readRegister), uint16(numReadRegs))
+		if err != nil {
+			return err
+		}
+		result = append([]byte{byte(numReadRegs * 2)}, Uint16ToBytes(holdingRegisters)...)
+		return nil
+	})
+	if err != nil {
+		return []byte{}, slaveException("update slave holdingRegisters", err)
 	}
 
-	if err = s.SaveHoldingRegisters(frame.Addr(), holdingRegisters); err != nil {
-		log.Printf("write slave holdingRegisters fail, err: %s\n", err.Error())
-		return []byte{}, &SlaveDeviceFailure
+	return result, &Success
+}
+
+// The quantity limits of the requests, so that the response fits a 253 bytes PDU.
+const (
+	maxReadBits           = 2000
+	maxReadRegisters      = 125
+	maxWriteBits          = 1968
+	maxWriteRegisters     = 123
+	maxReadWriteRegisters = 121
+)
+
+// checkReadRequest checks the starting address and the quantity of a read request.
+func checkReadRequest(frame Framer, maxQuantity int) *Exception {
+	if len(frame.GetData()) != 4 {
+		return &IllegalDataValue
+	}
+	_, numRegs, endRegister := registerAddressAndNumber(frame)
+	return checkQuantity(numRegs, endRegister, maxQuantity)
+}
+
+// checkWriteRequest checks the starting address, the quantity and the byte count of
+// a write multiple request of bits, 8 per byte, or of registers.
+func checkWriteRequest(frame Framer, maxQuantity int, bits bool) *Exception {
+	data := frame.GetData()
+	if len(data) < 5 {
+		return &IllegalDataValue
+	}
+	_, numRegs, endRegister := registerAddressAndNumber(frame)
+	byteCount := numRegs * 2
+	if bits {
+		byteCount = (numRegs + 7) / 8
 	}
+	if int(data[4]) != byteCount || len(data) != 5+byteCount {
+		return &IllegalDataValue
+	}
+	return checkQuantity(numRegs, endRegister, maxQuantity)
+}
 
-	return data, exception
+// checkQuantity checks the quantity of a request is in [1, maxQuantity], then that it ends in the table.
+func checkQuantity(numRegs int, endRegister int, maxQuantity int) *Exception {
+	if numRegs < 1 || numRegs > maxQuantity {
+		return &IllegalDataValue
+	}
+	if endRegister > tableLength {
+		return &IllegalDataAddress
+	}
+	return &Success
+}
+
+// slaveException returns the exception of a failed slave operation. An
+// Exception returned by the slave, like the IllegalDataAddress of an undefined
+// address, goes to the master, other errors are logged and are SlaveDeviceFailure.
+func slaveException(operation string, err error) *Exception {
+	var exception Exception
+	if errors.As(err, &exception) && exception != Success {
+		return &exception
+	}
+	log.Printf("%s fail, err: %s\n", operation, err.Error())
+	return &SlaveDeviceFailure
 }
 
 // BytesToUint16 converts a big endian array of bytes to an array of unit16s
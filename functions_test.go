@@ -33,7 +33,7 @@ func TestReadCoils(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 6
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 1
 	SetDataWithRegisterAndNumber(&frame, 10, 9)
 
@@ -76,7 +76,7 @@ func TestReadDiscreteInputs(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 6
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 2
 	SetDataWithRegisterAndNumber(&frame, 0, 10)
 
@@ -116,7 +116,7 @@ func TestReadHoldingRegisters(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 6
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 3
 	SetDataWithRegisterAndNumber(&frame, 100, 3)
 
@@ -155,7 +155,7 @@ func TestReadInputRegisters(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 6
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 4
 	SetDataWithRegisterAndNumber(&frame, 200, 3)
 
@@ -182,9 +182,9 @@ func TestWriteSingleCoil(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 12
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 5
-	SetDataWithRegisterAndNumber(&frame, 65535, 1024)
+	SetDataWithRegisterAndNumber(&frame, 65535, 0xFF00)
 
 	var req Request
 	req.frame = &frame
@@ -194,6 +194,13 @@ func TestWriteSingleCoil(t *testing.T) {
 		t.Errorf("expected Success, got %v", exception.String())
 		t.FailNow()
 	}
+
+	// Only 0x0000 and 0xFF00 are coil values.
+	SetDataWithRegisterAndNumber(&frame, 65535, 1024)
+	exception = GetException(s.handle(&req))
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
 	expect := 1
 	bsGot, err := s.Coils(1)
 	if err != nil {
@@ -214,7 +221,7 @@ func TestWriteHoldingRegister(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 12
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 6
 	SetDataWithRegisterAndNumber(&frame, 5, 6)
 
@@ -246,7 +253,7 @@ func TestWriteMultipleCoils(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 12
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 15
 	SetDataWithRegisterAndNumberAndBytes(&frame, 1, 2, []byte{3})
 
@@ -278,7 +285,7 @@ func TestWriteHoldingRegisters(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 12
-	frame.Device = 255
+	frame.Device = 1
 	frame.Function = 16
 	SetDataWithRegisterAndNumberAndValues(&frame, 1, 2, []uint16{3, 4})
 
@@ -302,6 +309,124 @@ func TestWriteHoldingRegisters(t *testing.T) {
 	}
 }
 
+// Function 22
+func TestMaskWriteRegister(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	if err := s.SaveHoldingRegisters(1, append(make([]uint16, 4), 0x12)); err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+		t.FailNow()
+	}
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 1
+	frame.Function = 22
+	// The example from the Modbus Application Protocol specification.
+	frame.SetData([]byte{0, 4, 0, 0xf2, 0, 0x25})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	expectData := []byte{0, 4, 0, 0xf2, 0, 0x25}
+	gotData := response.GetData()
+	if !isEqual(expectData, gotData) {
+		t.Errorf("expected %v, got %v", expectData, gotData)
+	}
+	holdingRegisters, err := s.HoldingRegisters(1)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+		t.FailNow()
+	}
+	expect := uint16(0x17)
+	got := holdingRegisters[4]
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	frame.Function = 22
+	frame.SetData([]byte{0, 4, 0, 0xf2})
+	response = s.handle(&req)
+	exception = GetException(response)
+	if exception != IllegalDataValue {
+		t.Errorf("expected IllegalDataValue, got %v", exception.String())
+	}
+}
+
+// Function 23
+func TestReadWriteMultipleRegisters(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	if err := s.SaveHoldingRegisters(1, append(make([]uint16, 10), 7, 8)); err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+		t.FailNow()
+	}
+
+	var frame TCPFrame
+	frame.TransactionIdentifier = 1
+	frame.ProtocolIdentifier = 0
+	frame.Device = 1
+	frame.Function = 23
+	// Read 3 registers from 9, write 2 registers to 10 and 11.
+	frame.SetData([]byte{0, 9, 0, 3, 0, 10, 0, 2, 4, 0, 3, 0, 4})
+
+	var req Request
+	req.frame = &frame
+	response := s.handle(&req)
+	exception := GetException(response)
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+		t.FailNow()
+	}
+	// The write is performed before the read.
+	expect := []byte{6, 0, 0, 0, 3, 0, 4}
+	got := response.GetData()
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
+
+func TestReadWriteMultipleRegistersInvalid(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	for _, tc := range []struct {
+		data   []byte
+		expect Exception
+	}{
+		// Read quantity 0.
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 1, 2, 0, 1}, IllegalDataValue},
+		// Read quantity 126.
+		{[]byte{0, 0, 0, 126, 0, 0, 0, 1, 2, 0, 1}, IllegalDataValue},
+		// Write quantity 122.
+		{append([]byte{0, 0, 0, 1, 0, 0, 0, 122, 244}, make([]byte, 244)...), IllegalDataValue},
+		// Byte count does not match the write quantity.
+		{[]byte{0, 0, 0, 1, 0, 0, 0, 2, 2, 0, 1}, IllegalDataValue},
+		// Read past the last register.
+		{[]byte{255, 255, 0, 2, 0, 0, 0, 1, 2, 0, 1}, IllegalDataAddress},
+		// Write past the last register.
+		{[]byte{0, 0, 0, 1, 255, 255, 0, 2, 4, 0, 1, 0, 2}, IllegalDataAddress},
+		// Short PDU.
+		{[]byte{0, 0, 0, 1}, IllegalDataValue},
+	} {
+		var frame TCPFrame
+		frame.Device = 1
+		frame.Function = 23
+		frame.SetData(tc.data)
+
+		var req Request
+		req.frame = &frame
+		response := s.handle(&req)
+		exception := GetException(response)
+		if exception != tc.expect {
+			t.Errorf("% x: expected %v, got %v", tc.data, tc.expect.String(), exception.String())
+		}
+	}
+}
+
 func TestBytesToUint16(t *testing.T) {
 	bytes := []byte{1, 2, 3, 4}
 	got := BytesToUint16(bytes)
@@ -327,7 +452,7 @@ func TestOutOfBounds(t *testing.T) {
 	frame.TransactionIdentifier = 1
 	frame.ProtocolIdentifier = 0
 	frame.Length = 6
-	frame.Device = 255
+	frame.Device = 1
 
 	var req Request
 	req.frame = &frame
@@ -382,3 +507,98 @@ func TestOutOfBounds(t *testing.T) {
 		t.Errorf("expected IllegalDataAddress, got %v", exception.String())
 	}
 }
+
+func TestRequestValidation(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+
+	tests := []struct {
+		name     string
+		function uint8
+		data     []byte
+		want     Exception
+	}{
+		{"read coils short", 1, []byte{0, 0, 0}, IllegalDataValue},
+		{"read coils long", 1, []byte{0, 0, 0, 1, 0}, IllegalDataValue},
+		{"read coils zero", 1, []byte{0, 0, 0, 0}, IllegalDataValue},
+		{"read coils 2000", 1, []byte{0, 0, 0x07, 0xD0}, Success},
+		{"read coils 2001", 1, []byte{0, 0, 0x07, 0xD1}, IllegalDataValue},
+		{"read coils last", 1, []byte{0xFF, 0xFF, 0, 1}, Success},
+		{"read discrete inputs 2001", 2, []byte{0, 0, 0x07, 0xD1}, IllegalDataValue},
+		{"read discrete inputs empty", 2, []byte{}, IllegalDataValue},
+		{"read holding registers 125", 3, []byte{0, 0, 0, 125}, Success},
+		{"read holding registers 126", 3, []byte{0, 0, 0, 126}, IllegalDataValue},
+		{"read holding registers short", 3, []byte{0}, IllegalDataValue},
+		{"read input registers 126", 4, []byte{0, 0, 0, 126}, IllegalDataValue},
+		{"read input registers past the end", 4, []byte{0xFF, 0xFF, 0, 2}, IllegalDataAddress},
+		{"write single coil short", 5, []byte{0, 0, 0xFF}, IllegalDataValue},
+		{"write single coil off", 5, []byte{0, 0, 0, 0}, Success},
+		{"write single coil 0x00FF", 5, []byte{0, 0, 0, 0xFF}, IllegalDataValue},
+		{"write single register short", 6, []byte{0, 0, 0}, IllegalDataValue},
+		{"write multiple coils short", 15, []byte{0, 0, 0, 1}, IllegalDataValue},
+		{"write multiple coils zero", 15, []byte{0, 0, 0, 0, 0}, IllegalDataValue},
+		{"write multiple coils byte count", 15, []byte{0, 0, 0, 9, 1, 0xFF}, IllegalDataValue},
+		{"write multiple coils missing bytes", 15, []byte{0, 0, 0, 9, 2, 0xFF}, IllegalDataValue},
+		{"write multiple coils 9", 15, []byte{0, 0, 0, 9, 2, 0xFF, 0x01}, Success},
+		{"write multiple coils 1969", 15, append([]byte{0, 0, 0x07, 0xB1, 247}, make([]byte, 247)...), IllegalDataValue},
+		{"write multiple coils past the end", 15, []byte{0xFF, 0xFF, 0, 2, 1, 0x03}, IllegalDataAddress},
+		{"write multiple registers short", 16, []byte{0, 0, 0, 1}, IllegalDataValue},
+		{"write multiple registers byte count", 16, []byte{0, 0, 0, 1, 3, 0, 1, 0}, IllegalDataValue},
+		{"write multiple registers extra bytes", 16, []byte{0, 0, 0, 1, 2, 0, 1, 0}, IllegalDataValue},
+		{"write multiple registers 123", 16, append([]byte{0, 0, 0, 123, 246}, make([]byte, 246)...), Success},
+		{"write multiple registers 124", 16, append([]byte{0, 0, 0, 124, 248}, make([]byte, 248)...), IllegalDataValue},
+		{"mask write register short", 22, []byte{0, 0, 0, 0, 0}, IllegalDataValue},
+		{"read write multiple registers short", 23, []byte{0, 0, 0, 1, 0, 0, 0, 1}, IllegalDataValue},
+		{"read write multiple registers 122 writes", 23, append([]byte{0, 0, 0, 1, 0, 0, 0, 122, 244}, make([]byte, 244)...), IllegalDataValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exception := GetException(handleRTU(s, tt.function, tt.data...))
+			if exception != tt.want {
+				t.Errorf("expected %v, got %v", tt.want.String(), exception.String())
+			}
+		})
+	}
+}
+
+// TestTruncatedRequests checks no handler panics on any prefix of a PDU.
+func TestTruncatedRequests(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	s.FileRecorder = NewMemoryFileRecordUint8(1)
+	s.FIFOQueuer = NewMemoryFIFOQueueUint8(1)
+	data := make([]byte, 260)
+	for i := range data {
+		data[i] = 0xFF
+	}
+	for function, handler := range s.function {
+		if handler == nil {
+			continue
+		}
+		for n := 0; n <= len(data); n++ {
+			frame := &RTUFrame{Address: 1, Function: uint8(function), Data: data[:n]}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("function %d with %d data bytes panics: %v", function, n, r)
+					}
+				}()
+				handler(s, frame)
+			}()
+		}
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	s.RegisterFunctionHandler(3, func(*Server, Framer) ([]byte, *Exception) {
+		panic("malformed request")
+	})
+	exception := GetException(handleRTU(s, 3, 0, 0, 0, 1))
+	if exception != SlaveDeviceFailure {
+		t.Errorf("expected SlaveDeviceFailure, got %v", exception.String())
+	}
+	// The server still answers.
+	exception = GetException(handleRTU(s, 4, 0, 0, 0, 1))
+	if exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+}
@@ -6,4 +6,8 @@ require (
 	github.com/goburrow/modbus v0.1.0
 	github.com/goburrow/serial v0.1.0
 	github.com/pkg/errors v0.9.1
+	go.etcd.io/bbolt v1.3.11
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -15,6 +15,9 @@ import (
 // The serial read and close has a known race condition.
 // https://github.com/golang/go/issues/10001
 func TestModbusRTU(t *testing.T) {
+	if _, err := exec.LookPath("socat"); err != nil {
+		t.Skip("socat is required to create virtual serial devices")
+	}
 	// Create a pair of virutal serial devices.
 	cmd := exec.Command("socat",
 		"pty,raw,echo=0,link=ttyFOO",
@@ -30,7 +33,7 @@ func TestModbusRTU(t *testing.T) {
 	time.Sleep(10 * time.Millisecond)
 
 	// Server
-	s := NewServer()
+	s := NewServer(NewMemorySlaveUint8(1))
 	err = s.ListenRTU(&serial.Config{
 		Address:  "ttyFOO",
 		BaudRate: 115200,
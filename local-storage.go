@@ -0,0 +1,468 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// The local storage files are hex encoded. A whole file write goes to
+// ${file}.tmp, which is synced and renamed over the file, the previous file is
+// kept as ${file}.bak if it decodes. A ranged write first saves the hex it
+// writes with its offset and a checksum to ${file}.journal and syncs it, then
+// writes the file in place and syncs it: two small synced writes instead of
+// rewriting the whole file, the journal is replayed if the write in place is
+// interrupted. A whole file write empties the journal first.
+// A journal that is not empty tells ranged writes were made since the last
+// good copy, which is then stale: localStorageRecover makes the file the last
+// good copy again and empties the journal.
+// Reads never modify the files, a file that is missing or does not decode
+// reads as its last good copy, an error if it is stale. localStorageRecover
+// repairs a file, it must not run concurrently with the reads of the file.
+const (
+	localStorageTmpSuffix     = ".tmp"
+	localStorageBackupSuffix  = ".bak"
+	localStorageJournalSuffix = ".journal"
+)
+
+// errCorruptFile is the cause of the error of a file that does not decode.
+var errCorruptFile = errors.New("corrupt file")
+
+// errStaleBackup is the cause of the error of a file that is missing or does
+// not decode while its last good copy is older than its ranged writes.
+var errStaleBackup = errors.New("last good copy older than the ranged writes")
+
+// localStorageFileRead reads and decodes the file, or its last good copy if it
+// is corrupt or missing and the copy is not stale; a file never written reads
+// as nil. It does not modify the files, see localStorageRecover.
+func localStorageFileRead(filePath string) (bsFileContent []byte, err error) {
+
+	if bsFileContent, err = localStorageDecodeFile(filePath); err == nil {
+		return
+	}
+	var corrupt = errors.Cause(err) == errCorruptFile
+	if !corrupt && !os.IsNotExist(errors.Cause(err)) {
+		return
+	}
+	if stale, journalErr := localStorageJournalWritten(filePath); journalErr != nil || stale {
+		if err = journalErr; stale {
+			err = errors.Wrap(errStaleBackup, "read file fail")
+		}
+		bsFileContent = nil
+		return
+	}
+
+	var backupErr error
+	if bsFileContent, backupErr = localStorageDecodeFile(filePath + localStorageBackupSuffix); backupErr != nil {
+		if !corrupt && os.IsNotExist(errors.Cause(backupErr)) {
+			// never written
+			bsFileContent, err = nil, nil
+			return
+		}
+		if corrupt {
+			err = errors.Wrap(err, "no good copy to recover from")
+		} else {
+			err = errors.Wrap(backupErr, "recover missing file fail")
+		}
+		bsFileContent = nil
+		return
+	}
+	log.Printf("read local storage file %s from its last good copy, err: %v\n", filePath, err)
+	err = nil
+	return
+}
+
+// localStorageRecover repairs the file: it replays the journal of an
+// interrupted ranged write, makes the file the last good copy if ranged writes
+// were made since the copy, then restores a file that is missing or does not
+// decode from its last good copy, unless the copy is stale. The caller holds
+// the write lock of the file, or no other goroutine uses it yet.
+func localStorageRecover(fileDir, filePath string) (err error) {
+
+	if err = localStorageReplayJournal(filePath); err != nil {
+		return
+	}
+	var stale bool
+	if stale, err = localStorageJournalWritten(filePath); err != nil {
+		return
+	}
+	var encodeBs []byte
+	if encodeBs, err = os.ReadFile(filePath); err == nil {
+		if _, err = localStorageDecodeFile(filePath); err == nil {
+			if stale {
+				err = localStorageRefreshBackup(fileDir, filePath, encodeBs)
+			}
+			return
+		}
+	}
+	var cause = errors.Cause(err)
+	if cause != errCorruptFile && !os.IsNotExist(cause) {
+		return
+	}
+	if stale {
+		err = errors.Wrap(errStaleBackup, "recover file fail")
+		return
+	}
+	var bsFileContent []byte
+	if bsFileContent, err = localStorageDecodeFile(filePath + localStorageBackupSuffix); err != nil {
+		if os.IsNotExist(cause) && os.IsNotExist(errors.Cause(err)) {
+			// never written
+			err = nil
+		}
+		return
+	}
+	log.Printf("recover local storage file %s from its last good copy\n", filePath)
+	encodeBs = make([]byte, hex.EncodedLen(len(bsFileContent)))
+	hex.Encode(encodeBs, bsFileContent)
+	err = localStorageWriteFile(fileDir, filePath, encodeBs, false)
+	return
+}
+
+// localStorageRefreshBackup replaces the last good copy of the file with its
+// hex encodeBs, then empties the journal, the copy is no longer stale.
+func localStorageRefreshBackup(fileDir, filePath string, encodeBs []byte) (err error) {
+
+	if err = localStorageWriteFile(fileDir, filePath+localStorageBackupSuffix, encodeBs, false); err != nil {
+		err = errors.Wrap(err, "refresh backup fail")
+		return
+	}
+	err = localStorageClearJournal(filePath)
+	return
+}
+
+// localStorageDecodeFile reads and decodes the file, the cause of the error is
+// an os error or errCorruptFile.
+func localStorageDecodeFile(filePath string) (bsFileContent []byte, err error) {
+
+	var encodeBs []byte
+	if encodeBs, err = os.ReadFile(filePath); err != nil {
+		err = errors.Wrap(err, "read file fail")
+		return
+	}
+	bsFileContent = make([]byte, hex.DecodedLen(len(encodeBs)))
+	if _, err = hex.Decode(bsFileContent, encodeBs); err != nil {
+		bsFileContent = nil
+		err = errors.Wrap(errCorruptFile, "hex decode file content fail: "+err.Error())
+	}
+	return
+}
+
+// localStorageWrite encodes and writes the file atomically, see localStorageWriteFile.
+func localStorageWrite(fileDir, filePath string, bsFileContent []byte) (n int, err error) {
+
+	var encodeBs = make([]byte, hex.EncodedLen(len(bsFileContent)))
+	hex.Encode(encodeBs, bsFileContent)
+	if err = localStorageWriteFile(fileDir, filePath, encodeBs, true); err == nil {
+		n = len(encodeBs)
+	}
+	return
+}
+
+// localStorageWriteFile writes ${filePath}.tmp, syncs it and renames it over
+// the file, then syncs the directory. If backup, the file it replaces becomes
+// the last good copy, unless it does not decode.
+func localStorageWriteFile(fileDir, filePath string, encodeBs []byte, backup bool) (err error) {
+
+	// mkdir all dir
+	if err = os.MkdirAll(fileDir, 0755); err != nil {
+		err = errors.Wrap(err, "mkdir all fail")
+		return
+	}
+	// write and sync the temp file
+	var tmpPath = filePath + localStorageTmpSuffix
+	var file *os.File
+	if file, err = os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		err = errors.Wrap(err, "open temp file fail")
+		return
+	}
+	if _, err = file.Write(encodeBs); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "write temp file content fail")
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "sync temp file fail")
+		return
+	}
+	if err = file.Close(); err != nil {
+		err = errors.Wrap(err, "close temp file fail")
+		return
+	}
+	// the journal must not be replayed over the new file
+	if err = localStorageClearJournal(filePath); err != nil {
+		return
+	}
+	// keep the last good copy, then replace the file
+	if _, decodeErr := localStorageDecodeFile(filePath); backup && decodeErr == nil {
+		if err = os.Rename(filePath, filePath+localStorageBackupSuffix); err != nil {
+			err = errors.Wrap(err, "rename file to backup fail")
+			return
+		}
+	}
+	if err = os.Rename(tmpPath, filePath); err != nil {
+		err = errors.Wrap(err, "rename temp file fail")
+		return
+	}
+	err = localStorageSyncDir(fileDir)
+	return
+}
+
+// localStorageSyncDir syncs the directory so the renames survive a power loss.
+func localStorageSyncDir(fileDir string) (err error) {
+
+	if runtime.GOOS == "windows" {
+		// directories cannot be synced, renames are durable once done
+		return
+	}
+	var dir *os.File
+	if dir, err = os.Open(fileDir); err != nil {
+		err = errors.Wrap(err, "open dir fail")
+		return
+	}
+	defer dir.Close()
+	if err = dir.Sync(); err != nil {
+		err = errors.Wrap(err, "sync dir fail")
+	}
+	return
+}
+
+// localStorageReadAt reads n bytes from offset of the file, the bytes past the
+// end of the file are zero. A file that is missing or does not decode is read
+// whole to recover it.
+func localStorageReadAt(filePath string, offset int64, n int) (bs []byte, err error) {
+
+	bs = make([]byte, n)
+	var file *os.File
+	if file, err = os.Open(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return localStorageReadAtWhole(filePath, offset, n)
+		}
+		err = errors.Wrap(err, "open file fail")
+		return
+	}
+	defer file.Close()
+	var fileInfo os.FileInfo
+	if fileInfo, err = file.Stat(); err != nil {
+		err = errors.Wrap(err, "stat file fail")
+		return
+	}
+	if fileInfo.Size()%2 != 0 {
+		return localStorageReadAtWhole(filePath, offset, n)
+	}
+	var encodeBs = make([]byte, hex.EncodedLen(n))
+	var read int
+	if read, err = file.ReadAt(encodeBs, int64(hex.EncodedLen(int(offset)))); err != nil && err != io.EOF {
+		err = errors.Wrap(err, "read file fail")
+		return
+	}
+	if _, err = hex.Decode(bs, encodeBs[:read]); err != nil {
+		return localStorageReadAtWhole(filePath, offset, n)
+	}
+	return
+}
+
+func localStorageReadAtWhole(filePath string, offset int64, n int) (bs []byte, err error) {
+
+	var bsFileContent []byte
+	if bsFileContent, err = localStorageFileRead(filePath); err != nil {
+		return
+	}
+	bs = make([]byte, n)
+	if offset < int64(len(bsFileContent)) {
+		copy(bs, bsFileContent[offset:])
+	}
+	return
+}
+
+// localStorageWriteAt writes bsContent at offset of the file, filling it with
+// zero bytes up to offset if it is shorter. The hex is written in place after
+// it is saved in the journal, see localStorageReplayJournal; a file that does
+// not exist yet, or has an odd length, is written whole.
+func localStorageWriteAt(fileDir, filePath string, offset int64, bsContent []byte) (err error) {
+
+	var fileInfo os.FileInfo
+	if fileInfo, err = os.Stat(filePath); err != nil || fileInfo.Size()%2 != 0 {
+		if err != nil && !os.IsNotExist(err) {
+			err = errors.Wrap(err, "stat file fail")
+			return
+		}
+		return localStorageWriteAtWhole(fileDir, filePath, offset, bsContent)
+	}
+
+	// the hex from the end of a shorter file is zero filled up to offset
+	var encodeOffset = int64(hex.EncodedLen(int(offset)))
+	var start = min(fileInfo.Size(), encodeOffset)
+	var encodeBs = make([]byte, int(encodeOffset-start)+hex.EncodedLen(len(bsContent)))
+	for i := range encodeBs[:encodeOffset-start] {
+		encodeBs[i] = '0'
+	}
+	hex.Encode(encodeBs[encodeOffset-start:], bsContent)
+
+	if err = localStorageWriteJournal(fileDir, filePath, start, encodeBs); err != nil {
+		return
+	}
+	err = localStorageWriteInPlace(filePath, start, encodeBs)
+	return
+}
+
+// localStorageWriteAtWhole writes bsContent at offset of the file by rewriting it whole.
+func localStorageWriteAtWhole(fileDir, filePath string, offset int64, bsContent []byte) (err error) {
+
+	var bsFileContent []byte
+	if bsFileContent, err = localStorageFileRead(filePath); err != nil {
+		return
+	}
+	if end := int(offset) + len(bsContent); len(bsFileContent) < end {
+		var newBs = make([]byte, end)
+		copy(newBs, bsFileContent)
+		bsFileContent = newBs
+	}
+	copy(bsFileContent[offset:], bsContent)
+	_, err = localStorageWrite(fileDir, filePath, bsFileContent)
+	return
+}
+
+// localStorageWriteInPlace writes the hex encodeBs at offset start of the file and syncs it.
+func localStorageWriteInPlace(filePath string, start int64, encodeBs []byte) (err error) {
+
+	var file *os.File
+	if file, err = os.OpenFile(filePath, os.O_WRONLY, 0644); err != nil {
+		err = errors.Wrap(err, "open file fail")
+		return
+	}
+	if _, err = file.WriteAt(encodeBs, start); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "write file content fail")
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "sync file fail")
+		return
+	}
+	if err = file.Close(); err != nil {
+		err = errors.Wrap(err, "close file fail")
+	}
+	return
+}
+
+// A journal record is the offset in the file (8 bytes), the length of the hex
+// (4 bytes), the hex, and the CRC-32 of all of them (4 bytes), big endian. A
+// record that is torn does not match its checksum and is not replayed, the file
+// was not written yet.
+const localStorageJournalHeaderLen = 12
+
+// localStorageWriteJournal saves the ranged write of the hex encodeBs at
+// offset start of the file to its journal and syncs it.
+func localStorageWriteJournal(fileDir, filePath string, start int64, encodeBs []byte) (err error) {
+
+	var record = make([]byte, localStorageJournalHeaderLen, localStorageJournalHeaderLen+len(encodeBs)+4)
+	binary.BigEndian.PutUint64(record[0:8], uint64(start))
+	binary.BigEndian.PutUint32(record[8:12], uint32(len(encodeBs)))
+	record = append(record, encodeBs...)
+	record = binary.BigEndian.AppendUint32(record, crc32.ChecksumIEEE(record))
+
+	var journalPath = filePath + localStorageJournalSuffix
+	var _, statErr = os.Stat(journalPath)
+	var file *os.File
+	if file, err = os.OpenFile(journalPath, os.O_CREATE|os.O_WRONLY, 0644); err != nil {
+		err = errors.Wrap(err, "open journal fail")
+		return
+	}
+	if _, err = file.WriteAt(record, 0); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "write journal fail")
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "sync journal fail")
+		return
+	}
+	if err = file.Close(); err != nil {
+		err = errors.Wrap(err, "close journal fail")
+		return
+	}
+	if os.IsNotExist(statErr) {
+		// the journal is kept once created, only its creation syncs the directory
+		err = localStorageSyncDir(fileDir)
+	}
+	return
+}
+
+// localStorageReplayJournal writes the ranged write saved in the journal of
+// the file again, if the journal holds a whole record and the file exists.
+func localStorageReplayJournal(filePath string) (err error) {
+
+	var record []byte
+	if record, err = os.ReadFile(filePath + localStorageJournalSuffix); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "read journal fail")
+		}
+		return
+	}
+	if len(record) < localStorageJournalHeaderLen {
+		return
+	}
+	var start = int64(binary.BigEndian.Uint64(record[0:8]))
+	var end = localStorageJournalHeaderLen + int(binary.BigEndian.Uint32(record[8:12]))
+	if end < localStorageJournalHeaderLen || end+4 > len(record) || binary.BigEndian.Uint32(record[end:end+4]) != crc32.ChecksumIEEE(record[:end]) {
+		// torn, the write in place did not start
+		return
+	}
+	if _, err = os.Stat(filePath); os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	err = localStorageWriteInPlace(filePath, start, record[localStorageJournalHeaderLen:end])
+	return
+}
+
+// localStorageJournalWritten reports whether the journal of the file is not
+// empty, ranged writes were made since the last good copy of the file.
+func localStorageJournalWritten(filePath string) (written bool, err error) {
+
+	var fileInfo os.FileInfo
+	if fileInfo, err = os.Stat(filePath + localStorageJournalSuffix); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "stat journal fail")
+		}
+		return
+	}
+	written = fileInfo.Size() > 0
+	return
+}
+
+// localStorageClearJournal empties the journal of the file, if it has one, and syncs it.
+func localStorageClearJournal(filePath string) (err error) {
+
+	var file *os.File
+	if file, err = os.OpenFile(filePath+localStorageJournalSuffix, os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = errors.Wrap(err, "open journal fail")
+		}
+		return
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "sync journal fail")
+		return
+	}
+	if err = file.Close(); err != nil {
+		err = errors.Wrap(err, "close journal fail")
+	}
+	return
+}
@@ -0,0 +1,248 @@
+package mbserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_localStorageFileRead(t *testing.T) {
+	type args struct {
+		file   string
+		backup string
+	}
+	tests := []struct {
+		name              string
+		args              args
+		wantBsFileContent []byte
+		wantFile          string
+		wantErr           bool
+	}{
+		{
+			name:              "test no exist file",
+			wantBsFileContent: nil,
+			wantErr:           false,
+		},
+		{
+			name:              "test good file",
+			args:              args{file: "12ab", backup: "0000"},
+			wantBsFileContent: []byte{0x12, 0xAB},
+			wantFile:          "12ab",
+			wantErr:           false,
+		},
+		{
+			name:              "test truncated file reads the good copy",
+			args:              args{file: "12a", backup: "0001"},
+			wantBsFileContent: []byte{0x00, 0x01},
+			wantFile:          "12a",
+			wantErr:           false,
+		},
+		{
+			name:              "test missing file reads the good copy",
+			args:              args{backup: "0002"},
+			wantBsFileContent: []byte{0x00, 0x02},
+			wantErr:           false,
+		},
+		{
+			name:              "test corrupt file without good copy",
+			args:              args{file: "zz", backup: "0"},
+			wantBsFileContent: nil,
+			wantFile:          "zz",
+			wantErr:           true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "1-holdingRegisters")
+			if tt.args.file != "" {
+				os.WriteFile(filePath, []byte(tt.args.file), 0644)
+			}
+			if tt.args.backup != "" {
+				os.WriteFile(filePath+localStorageBackupSuffix, []byte(tt.args.backup), 0644)
+			}
+			// Reads do not modify the files.
+			gotBsFileContent, err := localStorageFileRead(filePath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("localStorageFileRead() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotBsFileContent, tt.wantBsFileContent) {
+				t.Errorf("localStorageFileRead() = %v, want %v", gotBsFileContent, tt.wantBsFileContent)
+			}
+			if gotFile, _ := os.ReadFile(filePath); string(gotFile) != tt.wantFile {
+				t.Errorf("file = %s, want %s", gotFile, tt.wantFile)
+			}
+		})
+	}
+}
+
+func Test_localStorageWrite(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "1-coils")
+
+	for _, content := range [][]byte{{0x01}, {0x02}} {
+		if _, err := localStorageWrite(dir, filePath, content); err != nil {
+			t.Fatalf("localStorageWrite() error = %v", err)
+		}
+	}
+	if got, _ := os.ReadFile(filePath); string(got) != "02" {
+		t.Errorf("file = %s, want 02", got)
+	}
+	// The replaced file is the last good copy, no temp file is left.
+	if got, _ := os.ReadFile(filePath + localStorageBackupSuffix); string(got) != "01" {
+		t.Errorf("backup = %s, want 01", got)
+	}
+	if _, err := os.Stat(filePath + localStorageTmpSuffix); !os.IsNotExist(err) {
+		t.Errorf("temp file stat error = %v, want not exist", err)
+	}
+
+	// A power loss mid-write of the old code left a truncated file, it reads
+	// as its good copy and is written whole.
+	os.WriteFile(filePath, []byte("0"), 0644)
+	got, err := localStorageReadAt(filePath, 0, 2)
+	if err != nil {
+		t.Fatalf("localStorageReadAt() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []byte{0x01, 0x00}) {
+		t.Errorf("localStorageReadAt() = %v, want %v", got, []byte{0x01, 0x00})
+	}
+	if err = localStorageWriteAt(dir, filePath, 1, []byte{0xFF}); err != nil {
+		t.Fatalf("localStorageWriteAt() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filePath); string(got) != "01ff" {
+		t.Errorf("file = %s, want 01ff", got)
+	}
+	// The corrupt file did not replace the good copy.
+	if got, _ := os.ReadFile(filePath + localStorageBackupSuffix); string(got) != "01" {
+		t.Errorf("backup = %s, want 01", got)
+	}
+}
+
+func Test_localStorageWriteAt(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "1-holdingRegisters")
+
+	// The first write creates the file whole, the next ones write in place.
+	if err := localStorageWriteAt(dir, filePath, 0, []byte{0x01}); err != nil {
+		t.Fatalf("localStorageWriteAt() error = %v", err)
+	}
+	if err := localStorageWriteAt(dir, filePath, 3, []byte{0xAB, 0xCD}); err != nil {
+		t.Fatalf("localStorageWriteAt() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filePath); string(got) != "010000abcd" {
+		t.Errorf("file = %s, want 010000abcd", got)
+	}
+	if _, err := os.Stat(filePath + localStorageBackupSuffix); !os.IsNotExist(err) {
+		t.Errorf("backup stat error = %v, want not exist", err)
+	}
+
+	// A whole write empties the journal, so it is not replayed over the new file.
+	if _, err := localStorageWrite(dir, filePath, []byte{0x02}); err != nil {
+		t.Fatalf("localStorageWrite() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filePath + localStorageJournalSuffix); len(got) != 0 {
+		t.Errorf("journal = % x, want empty", got)
+	}
+	if err := localStorageRecover(dir, filePath); err != nil {
+		t.Fatalf("localStorageRecover() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filePath); string(got) != "02" {
+		t.Errorf("file = %s, want 02", got)
+	}
+}
+
+func Test_localStorageRecover(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		backup   string
+		journal  bool
+		torn     bool
+		wantFile string
+		wantErr  bool
+	}{
+		{name: "interrupted write in place is replayed", file: "01ff00", journal: true, wantFile: "01ab00"},
+		{name: "torn journal is not replayed", file: "01ff00", journal: true, torn: true, wantFile: "01ff00"},
+		{name: "journal of a missing file is not replayed", backup: "0103", journal: true, wantFile: "", wantErr: true},
+		{name: "stale good copy is not restored", file: "01z", backup: "0102", journal: true, torn: true, wantFile: "01z", wantErr: true},
+		{name: "corrupt file is restored", file: "01z", backup: "0102", wantFile: "0102"},
+		{name: "missing file is restored", backup: "0103", wantFile: "0103"},
+		{name: "never written", wantFile: ""},
+		{name: "corrupt file without good copy", file: "zz", wantFile: "zz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := filepath.Join(dir, "1-holdingRegisters")
+			if tt.file != "" {
+				os.WriteFile(filePath, []byte(tt.file), 0644)
+			}
+			if tt.backup != "" {
+				os.WriteFile(filePath+localStorageBackupSuffix, []byte(tt.backup), 0644)
+			}
+			if tt.journal {
+				// The journal of writing ab at byte 1, the power failed before the write in place.
+				if err := localStorageWriteJournal(dir, filePath, 2, []byte("ab")); err != nil {
+					t.Fatalf("localStorageWriteJournal() error = %v", err)
+				}
+			}
+			if tt.torn {
+				journal, _ := os.ReadFile(filePath + localStorageJournalSuffix)
+				os.WriteFile(filePath+localStorageJournalSuffix, journal[:len(journal)-1], 0644)
+			}
+			err := localStorageRecover(dir, filePath)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("localStorageRecover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got, _ := os.ReadFile(filePath); string(got) != tt.wantFile {
+				t.Errorf("file = %s, want %s", got, tt.wantFile)
+			}
+		})
+	}
+}
+
+func Test_localStorageStaleBackup(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "1-holdingRegisters")
+
+	// The good copy holds 0000, a ranged write then changes the file to 0002.
+	for _, content := range [][]byte{{0x00, 0x00}, {0x00, 0x01}} {
+		if _, err := localStorageWrite(dir, filePath, content); err != nil {
+			t.Fatalf("localStorageWrite() error = %v", err)
+		}
+	}
+	if err := localStorageWriteAt(dir, filePath, 1, []byte{0x02}); err != nil {
+		t.Fatalf("localStorageWriteAt() error = %v", err)
+	}
+
+	// The corrupt file does not read as the good copy older than the write,
+	// the journal holding only the last write does not repair it.
+	os.WriteFile(filePath, []byte("zz00"), 0644)
+	if got, err := localStorageFileRead(filePath); err == nil {
+		t.Errorf("localStorageFileRead() = %v, want an error", got)
+	}
+	if got, err := localStorageReadAt(filePath, 0, 2); err == nil {
+		t.Errorf("localStorageReadAt() = %v, want an error", got)
+	}
+	if err := localStorageRecover(dir, filePath); err == nil {
+		t.Errorf("localStorageRecover() error = nil, want an error")
+	}
+
+	// Recovering a good file makes it the good copy.
+	os.WriteFile(filePath, []byte("0002"), 0644)
+	if err := localStorageRecover(dir, filePath); err != nil {
+		t.Fatalf("localStorageRecover() error = %v", err)
+	}
+	if got, _ := os.ReadFile(filePath + localStorageBackupSuffix); string(got) != "0002" {
+		t.Errorf("backup = %s, want 0002", got)
+	}
+	os.WriteFile(filePath, []byte("zz00"), 0644)
+	got, err := localStorageFileRead(filePath)
+	if err != nil {
+		t.Fatalf("localStorageFileRead() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []byte{0x00, 0x02}) {
+		t.Errorf("localStorageFileRead() = %v, want %v", got, []byte{0x00, 0x02})
+	}
+}
@@ -0,0 +1,10 @@
+package mbserver
+
+// lrcModbus returns the Modbus ASCII longitudinal redundancy check of data:
+// the two's complement of the 8-bit sum of all bytes, carries discarded.
+func lrcModbus(data []byte) (lrc uint8) {
+	for _, v := range data {
+		lrc += v
+	}
+	return -lrc
+}
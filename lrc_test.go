@@ -0,0 +1,11 @@
+package mbserver
+
+import "testing"
+
+func TestLRC(t *testing.T) {
+	got := lrcModbus([]byte{0x11, 0x03, 0x00, 0x6B, 0x00, 0x03})
+	expect := 0x7E
+	if !isEqual(expect, got) {
+		t.Errorf("expected %x, got %x", expect, got)
+	}
+}
@@ -0,0 +1,216 @@
+package mbserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHandshakeTimeout bounds the TLS handshake of an mbaps connection when
+// Server.HandshakeTimeout is 0.
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// RoleOID is the X.509 extension carrying the role of a Modbus/TCP Security
+// client certificate, an ASN.1 UTF8String.
+var RoleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// RolePermission allows a role the requests of some function codes.
+type RolePermission struct {
+	// Functions are the allowed function codes.
+	Functions []uint8
+	// Addresses limits the reads and writes of the data functions, 1 to 6, 15,
+	// 16, 22 and 23, to these ranges, all addresses if empty.
+	Addresses []AddressRange
+}
+
+// RolePolicy authorizes the requests of the mbaps connections by the role of
+// their client certificate, see ListenMBAPS.
+type RolePolicy struct {
+	// Roles are the permissions by role, a request is allowed if a permission
+	// of the role allows it, the requests of unknown roles are denied.
+	Roles map[string][]RolePermission
+	// Denied is the exception of the denied requests, IllegalFunction if nil.
+	Denied *Exception
+}
+
+// authorize returns the exception of a denied request of role, nil if it is allowed.
+func (p *RolePolicy) authorize(role string, frame Framer) *Exception {
+
+	function := frame.GetFunction()
+	ranges, isData := requestRanges(frame)
+	for _, permission := range p.Roles[role] {
+		if permission.allows(function, ranges, isData) {
+			return nil
+		}
+	}
+	if p.Denied != nil {
+		return p.Denied
+	}
+	return &IllegalFunction
+}
+
+// allows reports if the permission allows function on the address ranges of a data function.
+func (p RolePermission) allows(function uint8, ranges []AddressRange, isData bool) bool {
+	allowed := false
+	for _, f := range p.Functions {
+		allowed = allowed || f == function
+	}
+	if !allowed || !isData || len(p.Addresses) == 0 {
+		return allowed
+	}
+	if ranges == nil {
+		// A malformed request can not be checked.
+		return false
+	}
+	for _, r := range ranges {
+		within := false
+		for _, permitted := range p.Addresses {
+			within = within || (permitted.First <= r.First && r.Last <= permitted.Last)
+		}
+		if !within {
+			return false
+		}
+	}
+	return true
+}
+
+// requestRanges returns the address ranges accessed by a request of a data
+// function, isData is false for the other functions, ranges nil if the request is too short.
+func requestRanges(frame Framer) (ranges []AddressRange, isData bool) {
+
+	// offsets are the offsets of the addresses in the data, followed by a quantity if quantity is set.
+	var offsets = []int{0}
+	var quantity = true
+	switch frame.GetFunction() {
+	case 1, 2, 3, 4, 15, 16:
+	case 5, 6, 22:
+		quantity = false
+	case 23:
+		offsets = []int{0, 4}
+	default:
+		return nil, false
+	}
+
+	data := frame.GetData()
+	for _, offset := range offsets {
+		if len(data) < offset+4 {
+			return nil, true
+		}
+		first := binary.BigEndian.Uint16(data[offset:])
+		last := uint32(first)
+		if count := binary.BigEndian.Uint16(data[offset+2:]); quantity && count > 0 {
+			last += uint32(count) - 1
+		}
+		if last > 0xFFFF {
+			// The handler rejects the request, the range only needs to cover it.
+			last = 0xFFFF
+		}
+		ranges = append(ranges, AddressRange{First: first, Last: uint16(last)})
+	}
+	return ranges, true
+}
+
+// certificateRole returns the role of a client certificate, an error if it has none.
+func certificateRole(cert *x509.Certificate) (string, error) {
+	for _, extension := range cert.Extensions {
+		if !extension.Id.Equal(RoleOID) {
+			continue
+		}
+		var role string
+		rest, err := asn1.UnmarshalWithParams(extension.Value, &role, "utf8")
+		if err != nil {
+			return "", errors.Wrap(err, "invalid role extension")
+		}
+		if len(rest) > 0 {
+			return "", errors.New("invalid role extension: trailing data")
+		}
+		return role, nil
+	}
+	return "", errors.Errorf("client certificate %q has no role", cert.Subject.CommonName)
+}
+
+// mbapsListener accepts the TLS connections of ListenMBAPS, their requests are authorized by policy.
+type mbapsListener struct {
+	net.Listener
+	policy *RolePolicy
+}
+
+// mbapsConfig returns config requiring and verifying the client certificates.
+func mbapsConfig(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if config.MinVersion < tls.VersionTLS12 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	return config
+}
+
+// ListenMBAPS starts the Modbus/TCP Security server listening on
+// "address:port", usually port 802. The clients must present a certificate
+// verified by config.ClientCAs, the system roots if nil, with a role
+// extension, see RoleOID; each request is checked against policy before its
+// handler runs and a denied request is answered with policy.Denied.
+func (s *Server) ListenMBAPS(addressPort string, config *tls.Config, policy *RolePolicy) (err error) {
+	if config == nil || policy == nil {
+		return errors.New("mbaps requires a TLS config and a role policy")
+	}
+	listen, err := net.Listen("tcp", addressPort)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Printf("Failed to Listen on mbaps: %s\n", err.Error())
+		return err
+	}
+	listen = &mbapsListener{tls.NewListener(listen, mbapsConfig(config)), policy}
+	return s.serveListener(listen, newTCPPacketReader, newTCPFramer)
+}
+
+// ServeMBAPS accepts the Modbus/TCP Security connections of listen, like
+// ListenMBAPS, until the server shuts down or listen fails. listen accepts the
+// TCP connections, the server runs the TLS handshakes.
+// It returns ErrServerClosed after Shutdown or Close.
+func (s *Server) ServeMBAPS(listen net.Listener, config *tls.Config, policy *RolePolicy) error {
+	if config == nil || policy == nil {
+		listen.Close()
+		return errors.New("mbaps requires a TLS config and a role policy")
+	}
+	return s.Serve(&mbapsListener{tls.NewListener(listen, mbapsConfig(config)), policy})
+}
+
+// authenticate runs the TLS handshake of an mbaps connection and sets its role.
+func (s *Server) authenticate(conn *serverConn) error {
+	// The handshake reads and writes the connection, an unauthenticated peer
+	// must not hold it forever.
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+	if !s.setConnDeadline(conn, time.Now().Add(timeout)) {
+		return errors.New("connection closed")
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	tlsConn, ok := conn.Conn.(*tls.Conn)
+	if !ok {
+		return errors.New("mbaps connection is not TLS")
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return errors.WithStack(err)
+	}
+	role, err := certificateRole(tlsConn.ConnectionState().PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	conn.role = role
+	s.lock.Unlock()
+	conn.origin = fmt.Sprintf("%s (%s)", conn.origin, role)
+	return nil
+}
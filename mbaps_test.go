@@ -0,0 +1,253 @@
+package mbserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA issues the certificates of the mbaps tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a server certificate for 127.0.0.1 if role is empty, else a
+// client certificate with the role extension, without it if role is "-".
+func (ca *testCA) issue(t *testing.T, name string, role string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	if role != "" {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		template.IPAddresses = nil
+	}
+	if role != "" && role != "-" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: RoleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMBAPS(t *testing.T) {
+	ca := newTestCA(t)
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &RolePolicy{Roles: map[string][]RolePermission{
+		"operator": {{Functions: []uint8{3, 6, 16}}},
+		"viewer":   {{Functions: []uint8{3, 4}, Addresses: []AddressRange{{0, 9}, {100, 109}}}},
+	}}
+	config := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", "")}, ClientCAs: ca.pool}
+	go s.ServeMBAPS(listen, config, policy)
+
+	// dial returns an mbaps connection presenting cert, none if cert is nil.
+	dial := func(cert *tls.Certificate) (*tls.Conn, error) {
+		config := &tls.Config{RootCAs: ca.pool}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		conn, err := tls.Dial("tcp", listen.Addr().String(), config)
+		if err != nil {
+			return nil, err
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		return conn, nil
+	}
+
+	operator := ca.issue(t, "operator", "operator")
+	viewer := ca.issue(t, "viewer", "viewer")
+	tests := []struct {
+		name     string
+		cert     tls.Certificate
+		request  []byte
+		response []byte
+	}{
+		{"operator write", operator,
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03},
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03}},
+		{"operator function", operator,
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x04, 0x00, 0x00, 0x00, 0x01},
+			[]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x01, 0x84, 0x01}},
+		{"viewer read", viewer,
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x02},
+			[]byte{0x00, 0x03, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x00, 0x00, 0x00, 0x03}},
+		{"viewer read range", viewer,
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x64, 0x00, 0x0A},
+			[]byte{0x00, 0x04, 0x00, 0x00, 0x00, 0x17, 0x01, 0x03, 0x14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"viewer read out of range", viewer,
+			[]byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x08, 0x00, 0x05},
+			[]byte{0x00, 0x05, 0x00, 0x00, 0x00, 0x03, 0x01, 0x83, 0x01}},
+		{"viewer write", viewer,
+			[]byte{0x00, 0x06, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x04},
+			[]byte{0x00, 0x06, 0x00, 0x00, 0x00, 0x03, 0x01, 0x86, 0x01}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := dial(&tt.cert)
+			if err != nil {
+				t.Fatalf("expected nil, got %v\n", err)
+			}
+			defer conn.Close()
+			if _, err := conn.Write(tt.request); err != nil {
+				t.Fatalf("expected nil, got %v\n", err)
+			}
+			got := make([]byte, len(tt.response))
+			if _, err := io.ReadFull(conn, got); err != nil {
+				t.Fatalf("expected nil, got %v\n", err)
+			}
+			if !isEqual(tt.response, got) {
+				t.Errorf("expected % x, got % x", tt.response, got)
+			}
+		})
+	}
+
+	// The write of the viewer was denied.
+	if values, _ := NewRangeSlaver(s.Slaver).ReadHoldingRegisters(1, 1, 1); values[0] != 3 {
+		t.Errorf("expected 3, got %d", values[0])
+	}
+
+	conn, err := dial(&viewer)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	defer conn.Close()
+	// The role is set once a response is received.
+	request := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err := conn.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 11)); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	infos := waitConnections(t, s, 1)
+	if infos[0].Role != "viewer" {
+		t.Errorf("expected viewer, got %q", infos[0].Role)
+	}
+
+	// The connections without a certificate or a role are refused.
+	noRole := ca.issue(t, "no role", "-")
+	for _, cert := range []*tls.Certificate{nil, &noRole} {
+		conn, err := dial(cert)
+		if err != nil {
+			continue
+		}
+		// A TLS 1.3 client learns the failure of the handshake on its first read.
+		conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01})
+		if n, err := conn.Read(make([]byte, 16)); err == nil {
+			t.Errorf("expected an error, got %d bytes", n)
+		}
+		conn.Close()
+	}
+}
+
+func TestMBAPSHandshakeTimeout(t *testing.T) {
+	ca := newTestCA(t)
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	// No IdleTimeout, the handshake is still bounded.
+	s.HandshakeTimeout = 50 * time.Millisecond
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{ca.issue(t, "server", "")}, ClientCAs: ca.pool}
+	go s.ServeMBAPS(listen, config, &RolePolicy{})
+
+	// The peer never sends its ClientHello.
+	conn, err := net.Dial("tcp", listen.Addr().String())
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n", err)
+	}
+	waitConnections(t, s, 0)
+}
+
+func TestRolePolicyDenied(t *testing.T) {
+	policy := &RolePolicy{
+		Roles:  map[string][]RolePermission{"engineer": {{Functions: []uint8{23}, Addresses: []AddressRange{{0, 99}}}}},
+		Denied: &IllegalDataAddress,
+	}
+	tests := []struct {
+		name string
+		role string
+		data []byte
+		want *Exception
+	}{
+		{"allowed", "engineer", []byte{0, 0, 0, 10, 0, 50, 0, 10, 20}, nil},
+		{"write out of range", "engineer", []byte{0, 0, 0, 10, 0, 95, 0, 10, 20}, &IllegalDataAddress},
+		{"truncated", "engineer", []byte{0, 0, 0, 10}, &IllegalDataAddress},
+		{"unknown role", "viewer", []byte{0, 0, 0, 10, 0, 50, 0, 10, 20}, &IllegalDataAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := &TCPFrame{Device: 1, Function: 23, Data: tt.data}
+			if got := policy.authorize(tt.role, frame); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile describes the slaves of a simulated device, it is loaded from a
+// YAML or JSON profile by LoadProfile:
+//
+//	slaves:
+//	  - id: 1
+//	    points:
+//	      - name: temperature
+//	        table: inputRegisters
+//	        address: 100
+//	        type: int16
+//	        value: 21.5
+//	        scale: 0.1
+//	      - name: setpoint
+//	        table: holdingRegisters
+//	        address: 0
+//	        type: float32
+//	        order: CDAB
+//	        value: 20
+//	      - name: running
+//	        table: coils
+//	        address: 0
+//	        type: bool
+//	        value: true
+//	        readOnly: true
+type Profile struct {
+	Slaves []ProfileSlave
+}
+
+// ProfileSlave is a slave of a Profile.
+type ProfileSlave struct {
+	Id     uint8
+	Points []ProfilePoint
+	// Line is the line of the slave in the profile.
+	Line int
+}
+
+// ProfilePoint is a named point of a ProfileSlave, it takes the entries from
+// Address of Table the Type needs.
+type ProfilePoint struct {
+	Name    string
+	Table   Table
+	Address uint16
+	// Type is bool for coils and discrete inputs, uint16, int16, uint32, int32,
+	// float32, uint64, int64 or float64 for registers.
+	Type string
+	// Order is the word order of the registers of the value, ABCD when omitted.
+	Order WordOrder
+	// Value is the initial value, 0 or 1 for bool.
+	Value float64
+	// Int64Value and Uint64Value are the exact initial value of an int64 or a
+	// uint64 point of scale 1, which Value rounds past 2^53. They are used
+	// while Value is their rounding.
+	Int64Value  int64
+	Uint64Value uint64
+	// Scale is the engineering value of 1 raw, the raw value is Value / Scale.
+	Scale float64
+	// ReadOnly points reject the writes of the master with IllegalDataAddress.
+	ReadOnly bool
+	// Line is the line of the point in the profile.
+	Line int
+}
+
+// ProfileError is an invalid profile, Line is the line of the offending node.
+type ProfileError struct {
+	Line    int
+	Message string
+}
+
+func (e *ProfileError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+func profileError(node *yaml.Node, format string, args ...interface{}) error {
+	return &ProfileError{Line: node.Line, Message: fmt.Sprintf(format, args...)}
+}
+
+// profileTypeWords is the number of registers of the types of register points.
+var profileTypeWords = map[string]int{
+	"uint16":  1,
+	"int16":   1,
+	"uint32":  2,
+	"int32":   2,
+	"float32": 2,
+	"uint64":  4,
+	"int64":   4,
+	"float64": 4,
+}
+
+// profileTypeLimits are the lowest value and the limit past the highest value of the integer types.
+var profileTypeLimits = map[string][2]float64{
+	"bool":   {0, 2},
+	"uint16": {0, 1 << 16},
+	"int16":  {-1 << 15, 1 << 15},
+	"uint32": {0, 1 << 32},
+	"int32":  {-1 << 31, 1 << 31},
+	"uint64": {0, 1 << 64},
+	"int64":  {-1 << 63, 1 << 63},
+}
+
+var profileTables = map[string]Table{
+	TableDiscreteInputs.String():   TableDiscreteInputs,
+	TableCoils.String():            TableCoils,
+	TableHoldingRegisters.String(): TableHoldingRegisters,
+	TableInputRegisters.String():   TableInputRegisters,
+}
+
+// LoadProfileFile loads the profile of file path, see LoadProfile.
+func LoadProfileFile(path string) (*Profile, error) {
+
+	var data, err = os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read profile")
+	}
+	var profile *Profile
+	if profile, err = LoadProfile(data); err != nil {
+		return nil, errors.Wrapf(err, "profile %s", path)
+	}
+	return profile, nil
+}
+
+// LoadProfile loads and validates a YAML or JSON profile, an invalid profile
+// returns a *ProfileError with the line of the offending node.
+func LoadProfile(data []byte) (*Profile, error) {
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, errors.Wrap(err, "parse profile")
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil, &ProfileError{Line: 1, Message: "empty profile"}
+	}
+	var fields, err = profileMapping(document.Content[0], "slaves")
+	if err != nil {
+		return nil, err
+	}
+	var slaves = fields["slaves"]
+	if slaves == nil {
+		return nil, profileError(document.Content[0], "missing slaves")
+	}
+	if slaves.Kind != yaml.SequenceNode {
+		return nil, profileError(slaves, "slaves is not a list")
+	}
+	var profile = new(Profile)
+	var ids = make(map[uint8]int)
+	for _, node := range slaves.Content {
+		var slave ProfileSlave
+		if slave, err = loadProfileSlave(node); err != nil {
+			return nil, err
+		}
+		if line, ok := ids[slave.Id]; ok {
+			return nil, profileError(node, "slave %d is already declared at line %d", slave.Id, line)
+		}
+		ids[slave.Id] = slave.Line
+		profile.Slaves = append(profile.Slaves, slave)
+	}
+	return profile, nil
+}
+
+func loadProfileSlave(node *yaml.Node) (slave ProfileSlave, err error) {
+
+	var fields map[string]*yaml.Node
+	if fields, err = profileMapping(node, "id", "points"); err != nil {
+		return
+	}
+	slave.Line = node.Line
+	if fields["id"] == nil {
+		err = profileError(node, "missing id")
+		return
+	}
+	if err = fields["id"].Decode(&slave.Id); err != nil || slave.Id == 0 {
+		err = profileError(fields["id"], "id %q is not a slave id in [1, 255]", fields["id"].Value)
+		return
+	}
+	var points = fields["points"]
+	if points == nil {
+		return
+	}
+	if points.Kind != yaml.SequenceNode {
+		err = profileError(points, "points is not a list")
+		return
+	}
+	var names = make(map[string]int)
+	for _, pointNode := range points.Content {
+		var point ProfilePoint
+		if point, err = loadProfilePoint(pointNode); err != nil {
+			return
+		}
+		if line, ok := names[point.Name]; ok {
+			err = profileError(pointNode, "point %s is already declared at line %d", point.Name, line)
+			return
+		}
+		for _, other := range slave.Points {
+			if other.Table == point.Table && point.Address <= other.last() && other.Address <= point.last() {
+				err = profileError(pointNode, "point %s overlaps point %s at line %d", point.Name, other.Name, other.Line)
+				return
+			}
+		}
+		names[point.Name] = point.Line
+		slave.Points = append(slave.Points, point)
+	}
+	return
+}
+
+func loadProfilePoint(node *yaml.Node) (point ProfilePoint, err error) {
+
+	var fields map[string]*yaml.Node
+	if fields, err = profileMapping(node, "name", "table", "address", "type", "order", "value", "scale", "readOnly"); err != nil {
+		return
+	}
+	point.Line = node.Line
+	point.Scale = 1
+	for _, key := range []string{"name", "table", "address", "type"} {
+		if fields[key] == nil {
+			err = profileError(node, "missing %s", key)
+			return
+		}
+	}
+	if err = fields["name"].Decode(&point.Name); err != nil || point.Name == "" {
+		err = profileError(fields["name"], "invalid name %q", fields["name"].Value)
+		return
+	}
+	var ok bool
+	if point.Table, ok = profileTables[fields["table"].Value]; !ok {
+		err = profileError(fields["table"], "unknown table %q", fields["table"].Value)
+		return
+	}
+	if err = fields["address"].Decode(&point.Address); err != nil {
+		err = profileError(fields["address"], "address %q is not in [0, 65535]", fields["address"].Value)
+		return
+	}
+	point.Type = fields["type"].Value
+	var isBitTable = point.Table == TableCoils || point.Table == TableDiscreteInputs
+	if _, ok = profileTypeWords[point.Type]; !(ok && !isBitTable) && !(point.Type == "bool" && isBitTable) {
+		err = profileError(fields["type"], "type %q is not a type of %s", point.Type, point.Table)
+		return
+	}
+	if int(point.Address)+point.words() > tableLength {
+		err = profileError(fields["address"], "%s at address %d ends past the end of %s", point.Type, point.Address, point.Table)
+		return
+	}
+	if node := fields["scale"]; node != nil {
+		if err = node.Decode(&point.Scale); err != nil || point.Scale == 0 || point.Type == "bool" {
+			err = profileError(node, "invalid scale %q of %s", node.Value, point.Type)
+			return
+		}
+	}
+	if node := fields["order"]; node != nil {
+		if point.Order, err = ParseWordOrder(node.Value); err != nil || point.Type == "bool" {
+			err = profileError(node, "invalid order %q of %s", node.Value, point.Type)
+			return
+		}
+	}
+	if node := fields["readOnly"]; node != nil {
+		if err = node.Decode(&point.ReadOnly); err != nil {
+			err = profileError(node, "readOnly %q is not a bool", node.Value)
+			return
+		}
+	}
+	if node := fields["value"]; node != nil {
+		var accuracy big.Accuracy
+		switch {
+		case point.Type == "bool":
+			var value bool
+			err = node.Decode(&value)
+			if value {
+				point.Value = 1
+			}
+		case point.Type == "int64" && node.Decode(&point.Int64Value) == nil:
+			point.Value, accuracy = new(big.Float).SetInt64(point.Int64Value).Float64()
+		case point.Type == "uint64" && node.Decode(&point.Uint64Value) == nil:
+			point.Value, accuracy = new(big.Float).SetUint64(point.Uint64Value).Float64()
+		default:
+			err = node.Decode(&point.Value)
+		}
+		if err != nil {
+			err = profileError(node, "value %q is not a %s", node.Value, point.Type)
+			return
+		}
+		if _, exact := point.exact(); accuracy != big.Exact && !exact {
+			err = profileError(node, "value %q of scale %v is not exact in float64", node.Value, point.Scale)
+			return
+		}
+		if _, err = point.raw(); err != nil {
+			err = profileError(node, "%s", err.Error())
+			return
+		}
+	}
+	return
+}
+
+// profileMapping returns the values of the keys of mapping node, an error for a key not in keys.
+func profileMapping(node *yaml.Node, keys ...string) (map[string]*yaml.Node, error) {
+
+	if node.Kind != yaml.MappingNode {
+		return nil, profileError(node, "expected a mapping of %v", keys)
+	}
+	var fields = make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var key = node.Content[i]
+		var known bool
+		for _, k := range keys {
+			known = known || k == key.Value
+		}
+		if !known {
+			return nil, profileError(key, "unknown field %q, expected one of %v", key.Value, keys)
+		}
+		if _, ok := fields[key.Value]; ok {
+			return nil, profileError(key, "duplicate field %q", key.Value)
+		}
+		fields[key.Value] = node.Content[i+1]
+	}
+	return fields, nil
+}
+
+// words returns the number of entries of the point.
+func (p ProfilePoint) words() int {
+
+	if p.Type == "bool" {
+		return 1
+	}
+	return profileTypeWords[p.Type]
+}
+
+// last returns the address of the last entry of the point.
+func (p ProfilePoint) last() uint16 { return p.Address + uint16(p.words()-1) }
+
+// exact returns the bits of the initial value of an int64 or uint64 point of
+// scale 1 from Int64Value or Uint64Value, false if Value is not their rounding.
+func (p ProfilePoint) exact() (uint64, bool) {
+
+	switch {
+	case p.Scale != 1:
+	case p.Type == "int64" && float64(p.Int64Value) == p.Value:
+		return uint64(p.Int64Value), true
+	case p.Type == "uint64" && float64(p.Uint64Value) == p.Value:
+		return p.Uint64Value, true
+	}
+	return 0, false
+}
+
+// raw returns the entries of the initial value of the point.
+func (p ProfilePoint) raw() ([]uint16, error) {
+
+	var value = p.Value / p.Scale
+	var b []byte
+	switch p.Type {
+	case "float32":
+		if math.Abs(value) > math.MaxFloat32 {
+			return nil, errors.Errorf("value %v is out of range of float32", p.Value)
+		}
+		b = binary.BigEndian.AppendUint32(nil, math.Float32bits(float32(value)))
+	case "float64":
+		b = binary.BigEndian.AppendUint64(nil, math.Float64bits(value))
+	default:
+		var bits, exact = p.exact()
+		if !exact {
+			value = math.Round(value)
+			if limit := profileTypeLimits[p.Type]; value < limit[0] || value >= limit[1] {
+				return nil, errors.Errorf("value %v is out of range of %s", p.Value, p.Type)
+			}
+			bits = uint64(value)
+			if value < 0 {
+				bits = uint64(int64(value))
+			}
+		}
+		b = binary.BigEndian.AppendUint64(nil, bits)[8-2*p.words():]
+	}
+	return OrderedBytesToUint16(b, p.Order), nil
+}
+
+// NewSlaver returns a sparse slave of the points of the profile, set to their
+// initial values, see NewSparseSlaveUint8.
+func (p *Profile) NewSlaver() (Slaver, error) {
+
+	var maps = make(map[uint8]SlaveMap)
+	var slaver = &profileSlave{}
+	for _, slave := range p.Slaves {
+		var slaveMap SlaveMap
+		for _, point := range slave.Points {
+			var addressRange = AddressRange{First: point.Address, Last: point.last()}
+			switch point.Table {
+			case TableDiscreteInputs:
+				slaveMap.DiscreteInputs = append(slaveMap.DiscreteInputs, addressRange)
+			case TableCoils:
+				slaveMap.Coils = append(slaveMap.Coils, addressRange)
+			case TableHoldingRegisters:
+				slaveMap.HoldingRegisters = append(slaveMap.HoldingRegisters, addressRange)
+			case TableInputRegisters:
+				slaveMap.InputRegisters = append(slaveMap.InputRegisters, addressRange)
+			}
+			if point.ReadOnly {
+				slaver.readOnly[slave.Id][point.Table] = append(slaver.readOnly[slave.Id][point.Table], addressRange)
+			}
+		}
+		maps[slave.Id] = slaveMap
+	}
+	var sparse, err = NewSparseSlaveUint8(maps)
+	if err != nil {
+		return nil, err
+	}
+	slaver.sparseSlaveUint8 = sparse.(*sparseSlaveUint8)
+
+	for _, slave := range p.Slaves {
+		err = slaver.sparseSlaveUint8.Update(slave.Id, func(tables SlaveTables) error {
+			for _, point := range slave.Points {
+				var raw, err = point.raw()
+				if err != nil {
+					return errors.Wrapf(err, "point %s", point.Name)
+				}
+				switch point.Table {
+				case TableDiscreteInputs:
+					err = tables.WriteDiscreteInputs(point.Address, []byte{byte(raw[0])})
+				case TableCoils:
+					err = tables.WriteCoils(point.Address, []byte{byte(raw[0])})
+				case TableHoldingRegisters:
+					err = tables.WriteHoldingRegisters(point.Address, raw)
+				case TableInputRegisters:
+					err = tables.WriteInputRegisters(point.Address, raw)
+				}
+				if err != nil {
+					return errors.Wrapf(err, "point %s", point.Name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "slave %d", slave.Id)
+		}
+	}
+	return slaver, nil
+}
+
+// NewServer returns a server of the slaver of the profile, see NewSlaver.
+func (p *Profile) NewServer() (*Server, error) {
+
+	var slaver, err = p.NewSlaver()
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(slaver), nil
+}
+
+var _ masterTablesSlaver = new(profileSlave)
+
+// profileSlave is the sparse slave of a profile, the writes of the masters to
+// its read only points are rejected, the application still writes them.
+type profileSlave struct {
+	*sparseSlaveUint8
+	readOnly [256][4][]AddressRange
+}
+
+func (s *profileSlave) masterTables(id uint8, tables SlaveTables) SlaveTables {
+	return readOnlyTables{tables, &s.readOnly[id]}
+}
+
+// readOnlyTables returns IllegalDataAddress for the writes touching a read only range.
+type readOnlyTables struct {
+	SlaveTables
+	readOnly *[4][]AddressRange
+}
+
+func (t readOnlyTables) check(table Table, start uint16, count int) error {
+
+	var last = int(start) + count - 1
+	for _, addressRange := range t.readOnly[table] {
+		if int(start) <= int(addressRange.Last) && int(addressRange.First) <= last {
+			return IllegalDataAddress
+		}
+	}
+	return nil
+}
+
+func (t readOnlyTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := t.check(TableDiscreteInputs, start, len(values)); err != nil {
+		return err
+	}
+	return t.SlaveTables.WriteDiscreteInputs(start, values)
+}
+
+func (t readOnlyTables) WriteCoils(start uint16, values []byte) error {
+	if err := t.check(TableCoils, start, len(values)); err != nil {
+		return err
+	}
+	return t.SlaveTables.WriteCoils(start, values)
+}
+
+func (t readOnlyTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := t.check(TableHoldingRegisters, start, len(values)); err != nil {
+		return err
+	}
+	return t.SlaveTables.WriteHoldingRegisters(start, values)
+}
+
+func (t readOnlyTables) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := t.check(TableInputRegisters, start, len(values)); err != nil {
+		return err
+	}
+	return t.SlaveTables.WriteInputRegisters(start, values)
+}
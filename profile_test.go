@@ -0,0 +1,208 @@
+package mbserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+const testProfile = `slaves:
+  - id: 1
+    points:
+      - name: temperature
+        table: inputRegisters
+        address: 100
+        type: int16
+        value: -21.5
+        scale: 0.1
+      - name: setpoint
+        table: holdingRegisters
+        address: 0
+        type: float32
+        value: 20
+      - name: counter
+        table: holdingRegisters
+        address: 2
+        type: uint32
+        value: 70000
+        readOnly: true
+      - name: running
+        table: coils
+        address: 0
+        type: bool
+        value: true
+  - id: 2
+`
+
+func TestLoadProfile(t *testing.T) {
+	profile, err := LoadProfile([]byte(testProfile))
+	if err != nil {
+		t.Fatalf("LoadProfile() error = %v", err)
+	}
+	if len(profile.Slaves) != 2 || len(profile.Slaves[0].Points) != 4 || profile.Slaves[1].Id != 2 {
+		t.Fatalf("LoadProfile() = %+v", profile)
+	}
+	if point := profile.Slaves[0].Points[2]; point.Line != 15 || point.Table != TableHoldingRegisters || !point.ReadOnly {
+		t.Errorf("LoadProfile() point = %+v, want line 15, holding registers, read only", point)
+	}
+
+	s, err := profile.NewServer()
+	if err != nil {
+		t.Fatalf("Profile.NewServer() error = %v", err)
+	}
+	if !s.IsSlaveIdValid(2) || s.IsSlaveIdValid(3) {
+		t.Errorf("expected slaves 1 and 2")
+	}
+
+	tests := []struct {
+		name     string
+		function uint8
+		data     []byte
+		want     []byte
+	}{
+		{"temperature", 4, []byte{0, 100, 0, 1}, []byte{2, 0xFF, 0x29}},
+		{"setpoint and counter", 3, []byte{0, 0, 0, 4}, []byte{8, 0x41, 0xA0, 0, 0, 0x00, 0x01, 0x11, 0x70}},
+		{"running", 1, []byte{0, 0, 0, 1}, []byte{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := handleRTU(s, tt.function, tt.data...)
+			if exception := GetException(response); exception != Success {
+				t.Fatalf("expected Success, got %v", exception.String())
+			}
+			if got := response.GetData(); !isEqual(tt.want, got) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// The master can not write a read only point, nor an undefined address.
+	for _, data := range [][]byte{{0, 3, 0, 1}, {0, 4, 0, 1}} {
+		if exception := GetException(handleRTU(s, 6, data...)); exception != IllegalDataAddress {
+			t.Errorf("expected IllegalDataAddress, got %v", exception.String())
+		}
+	}
+	if exception := GetException(handleRTU(s, 6, 0, 1, 0, 1)); exception != Success {
+		t.Errorf("expected Success, got %v", exception.String())
+	}
+	// The program simulating the device still can.
+	if err = s.rangeSlaver().WriteHoldingRegisters(1, 3, []uint16{0}); err != nil {
+		t.Errorf("WriteHoldingRegisters() error = %v", err)
+	}
+	accessor := NewRegisterAccessor(s.Slaver, TableHoldingRegisters)
+	if err = accessor.SetUint32(1, 2, 80000, ABCD); err != nil {
+		t.Errorf("SetUint32() error = %v", err)
+	}
+	if got, _ := accessor.GetUint32(1, 2, ABCD); got != 80000 {
+		t.Errorf("GetUint32() = %v, want 80000", got)
+	}
+}
+
+func TestLoadProfileJSON(t *testing.T) {
+	profile, err := LoadProfile([]byte(`{
+  "slaves": [
+    {
+      "id": 7,
+      "points": [
+        {"name": "level", "table": "holdingRegisters", "address": 10, "type": "uint16", "value": 500, "scale": 0.5},
+        {"name": "energy", "table": "inputRegisters", "address": 0, "type": "int64", "order": "CDAB", "value": -2}
+      ]
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("LoadProfile() error = %v", err)
+	}
+	slaver, err := profile.NewSlaver()
+	if err != nil {
+		t.Fatalf("Profile.NewSlaver() error = %v", err)
+	}
+	values, err := NewRangeSlaver(slaver).ReadHoldingRegisters(7, 10, 1)
+	if err != nil || !reflect.DeepEqual(values, []uint16{1000}) {
+		t.Errorf("ReadHoldingRegisters() = %v, %v, want %v", values, err, []uint16{1000})
+	}
+	energy, err := NewRegisterAccessor(slaver, TableInputRegisters).GetInt64(7, 0, CDAB)
+	if err != nil || energy != -2 {
+		t.Errorf("GetInt64() = %v, %v, want %v", energy, err, -2)
+	}
+}
+
+func TestLoadProfile64Bits(t *testing.T) {
+	profile, err := LoadProfile([]byte(`slaves:
+  - id: 1
+    points:
+      - {name: a, table: holdingRegisters, address: 0, type: int64, value: 9007199254740993}
+      - {name: b, table: holdingRegisters, address: 4, type: int64, value: -9223372036854775808}
+      - {name: c, table: holdingRegisters, address: 8, type: uint64, value: 18446744073709551615}
+`))
+	if err != nil {
+		t.Fatalf("LoadProfile() error = %v", err)
+	}
+	slaver, err := profile.NewSlaver()
+	if err != nil {
+		t.Fatalf("Profile.NewSlaver() error = %v", err)
+	}
+	accessor := NewRegisterAccessor(slaver, TableHoldingRegisters)
+	for _, tt := range []struct {
+		address uint16
+		want    int64
+	}{{0, 9007199254740993}, {4, -9223372036854775808}} {
+		if got, err := accessor.GetInt64(1, tt.address, ABCD); err != nil || got != tt.want {
+			t.Errorf("GetInt64(%d) = %v, %v, want %v", tt.address, got, err, tt.want)
+		}
+	}
+	if got, err := accessor.GetUint64(1, 8, ABCD); err != nil || got != 18446744073709551615 {
+		t.Errorf("GetUint64() = %v, %v, want %v", got, err, uint64(18446744073709551615))
+	}
+}
+
+func TestLoadProfileError(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		wantLine int
+	}{
+		{"missing slaves", "devices: []\n", 1},
+		{"unknown field", "slaves:\n  - id: 1\n    adress: 2\n", 3},
+		{"broadcast id", "slaves:\n  - id: 0\n", 2},
+		{"duplicate id", "slaves:\n  - id: 1\n  - id: 1\n", 3},
+		{"unknown table", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: registers, address: 0, type: uint16}\n", 4},
+		{"bool register", "slaves:\n  - id: 1\n    points:\n      - name: a\n        table: holdingRegisters\n        address: 0\n        type: bool\n", 7},
+		{"address past the end", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: inputRegisters, address: 65535, type: int32}\n", 4},
+		{"value out of range", "slaves:\n  - id: 1\n    points:\n      - name: a\n        table: holdingRegisters\n        address: 0\n        type: int16\n        scale: 0.1\n        value: 4000\n", 9},
+		{"scaled value not exact", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: holdingRegisters, address: 0, type: uint64, scale: 10, value: 9007199254740993}\n", 4},
+		{"unknown order", "slaves:\n  - id: 1\n    points:\n      - name: a\n        table: holdingRegisters\n        address: 0\n        type: float64\n        order: BA\n", 8},
+		{"bool order", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: coils, address: 0, type: bool, order: DCBA}\n", 4},
+		{"missing type", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: coils, address: 0}\n", 4},
+		{"overlap", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: holdingRegisters, address: 0, type: float32}\n      - {name: b, table: holdingRegisters, address: 1, type: uint16}\n", 5},
+		{"duplicate name", "slaves:\n  - id: 1\n    points:\n      - {name: a, table: coils, address: 0, type: bool}\n      - {name: a, table: coils, address: 1, type: bool}\n", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadProfile([]byte(tt.profile))
+			var profileErr *ProfileError
+			if !errors.As(err, &profileErr) {
+				t.Fatalf("LoadProfile() error = %v, want a *ProfileError", err)
+			}
+			if profileErr.Line != tt.wantLine {
+				t.Errorf("LoadProfile() error = %v, want line %d", err, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestLoadProfileFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "device.yaml")
+	if err := os.WriteFile(path, []byte(testProfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadProfileFile(path); err != nil {
+		t.Errorf("LoadProfileFile() error = %v", err)
+	}
+	if _, err := LoadProfileFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Errorf("LoadProfileFile() error = nil, want an error")
+	}
+}
@@ -0,0 +1,31 @@
+package mbserver
+
+import (
+	"log"
+
+	"github.com/goburrow/serial"
+	"github.com/pkg/errors"
+)
+
+// ListenASCII starts the Modbus server listening to a serial device in ASCII transmission mode.
+// For example:  err := s.ListenASCII(&serial.Config{Address: "/dev/ttyUSB0", DataBits: 7, Parity: "E"})
+func (s *Server) ListenASCII(serialConfig *serial.Config) (err error) {
+	port, err := serial.Open(serialConfig)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Printf("failed to open %s: %s\n", serialConfig.Address, err.Error())
+		return err
+	}
+
+	return s.servePort(port, func() packetReader {
+		return newASCIIReader(serialReader{port, s.portsCloseChan}, s.asciiInputDelimiter)
+	}, newASCIIFramer)
+}
+
+func newASCIIFramer(packet []byte) (Framer, error) {
+	frame, err := NewASCIIFrame(packet)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -2,29 +2,88 @@
 package mbserver
 
 import (
+	"context"
 	"io"
 	"log"
 	"net"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/goburrow/serial"
+	"github.com/pkg/errors"
 )
 
 // Server is a Modbus slave with allocated memory for discrete inputs, coils, etc.
 type Server struct {
 	// Debug enables more verbose messaging.
-	Debug          bool
-	listeners      []net.Listener
-	ports          []serial.Port
+	Debug bool
+	// ConcurrentDispatch handles the requests of every connection, UDP socket
+	// and serial port in its own goroutine instead of one at a time for the
+	// whole server. Requests for different slave ids and reads of the same
+	// slave run in parallel, the other requests of a slave wait on its lock.
+	// The responses of a connection keep the order of its requests. The
+	// Slaver, FileRecorder and FIFOQueuer must be safe for concurrent use.
+	// Set it before listening.
+	ConcurrentDispatch bool
+
+	// The connection settings below apply to the connections served after they
+	// are set, set them before listening.
+
+	// MaxConnections limits the connections of the TCP listeners and ServeConn,
+	// 0 is no limit. At the limit a new connection closes the connection idle
+	// for the longest time, or is closed itself if none is idle.
+	MaxConnections int
+	// IdleTimeout closes a connection waiting for a request for longer, 0 is no timeout.
+	IdleTimeout time.Duration
+	// WriteTimeout closes a connection that takes longer to accept a response, 0 is no timeout.
+	WriteTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period of the connections of the TCP
+	// listeners, 0 keeps the default of the listener, negative disables keep-alives.
+	KeepAlive time.Duration
+	// HandshakeTimeout closes an mbaps connection whose TLS handshake takes
+	// longer, 0 is DefaultHandshakeTimeout. It applies whatever IdleTimeout is.
+	HandshakeTimeout time.Duration
+
+	// lock guards the listeners, packetConns, ports, conns and closing.
+	lock        sync.Mutex
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	ports       []io.ReadWriteCloser
+	conns       map[*serverConn]struct{}
+	closing     bool
+	// serveWG counts the goroutines of the listeners, connections, UDP sockets and serial ports.
+	serveWG sync.WaitGroup
+	// portsWG counts the goroutines reading the UDP sockets and the serial ports,
+	// which are closed once they return.
 	portsWG        sync.WaitGroup
 	portsCloseChan chan struct{}
-	requestChan    chan *Request
-	function       [256](func(*Server, Framer) ([]byte, *Exception))
+	serveErrs      chan error
+	// handleLock serializes the requests without ConcurrentDispatch.
+	handleLock sync.Mutex
+	// slaveLocks serialize the requests of a slave under ConcurrentDispatch.
+	slaveLocks [256]sync.RWMutex
+	function   [256](func(*Server, Framer) ([]byte, *Exception))
+	mei        [256](func(*Server, Framer) ([]byte, *Exception))
+	// deviceIdentifications are the Read Device Identification objects by slave id.
+	deviceIdentifications     [256]*DeviceIdentification
+	deviceIdentificationsLock sync.RWMutex
+	// diagnostics are the serial line counters and event logs by slave id.
+	diagnostics [256]slaveDiagnostics
+	// busMessages and busCommErrors count the messages and the communication
+	// errors seen on the serial lines, see countBusMessage.
+	busMessages    atomic.Uint32
+	busCommErrors  atomic.Uint32
+	asciiDelimiter atomic.Uint32
 	// DiscreteInputs   []byte
 	// Coils            []byte
 	// HoldingRegisters []uint16
 	// InputRegisters   []uint16
 	Slaver
+	// FileRecorder backs Read File Record and Write File Record, they return IllegalFunction if it is nil.
+	FileRecorder FileRecorder
+	// FIFOQueuer backs Read FIFO Queue, it returns IllegalFunction if it is nil.
+	FIFOQueuer FIFOQueuer
 }
 
 // Request contains the connection and Modbus frame.
@@ -50,37 +109,108 @@ func NewServer(slaver Slaver) *Server {
 	s.function[4] = SlaveOperate(ReadInputRegisters)
 	s.function[5] = SlaveOperate(WriteSingleCoil)
 	s.function[6] = SlaveOperate(WriteHoldingRegister)
+	s.function[7] = SlaveOperate(ReadExceptionStatus)
+	s.function[8] = SlaveOperate(Diagnostics)
+	s.function[11] = SlaveOperate(GetCommEventCounter)
+	s.function[12] = SlaveOperate(GetCommEventLog)
 	s.function[15] = SlaveOperate(WriteMultipleCoils)
 	s.function[16] = SlaveOperate(WriteHoldingRegisters)
+	s.function[17] = SlaveOperate(ReportServerId)
+	s.function[20] = SlaveOperate(ReadFileRecord)
+	s.function[21] = SlaveOperate(WriteFileRecord)
+	s.function[22] = SlaveOperate(MaskWriteRegister)
+	s.function[23] = SlaveOperate(ReadWriteMultipleRegisters)
+	s.function[24] = SlaveOperate(ReadFIFOQueue)
+	s.function[43] = SlaveOperate(EncapsulatedInterfaceTransport)
 
-	s.requestChan = make(chan *Request)
-	s.portsCloseChan = make(chan struct{})
+	// Add default MEI types of function 43.
+	s.mei[meiReadDeviceIdentification] = ReadDeviceIdentification
 
-	go s.handler()
+	s.asciiDelimiter.Store('\n')
+	s.conns = make(map[*serverConn]struct{})
+	s.portsCloseChan = make(chan struct{})
+	s.serveErrs = make(chan error, 1)
 
 	return s
 }
 
 // RegisterFunctionHandler override the default behavior for a given Modbus function.
+// Under ConcurrentDispatch the handlers of functions 1-4, 7, 11, 12, 17, 20, 24
+// and 43 run in parallel for a slave, so they must not change its data.
 func (s *Server) RegisterFunctionHandler(funcCode uint8, function func(*Server, Framer) ([]byte, *Exception)) {
 	s.function[funcCode] = function
 }
 
+// rangeSlaver returns the Slaver as a RangeSlaver, see NewRangeSlaver.
+func (s *Server) rangeSlaver() RangeSlaver {
+	return NewRangeSlaver(s.Slaver)
+}
+
+// masterTablesSlaver is a Slaver restricting the writes of the masters, such as
+// the read only points of a profile. The writes of the application are not.
+type masterTablesSlaver interface {
+	// masterTables returns the tables of slave id as the masters may write them.
+	masterTables(id uint8, tables SlaveTables) SlaveTables
+}
+
+// update runs fn in a transaction on the tables of the slave of the request
+// frame, see SlaveUpdater. Without a SlaveUpdater the writes are still saved
+// only if fn returns nil, but the slave is not locked, the server's dispatch
+// keeps its writes apart. The tables are those a master may write, see
+// masterTablesSlaver, and a SlaveOriginUpdater records the origin of the
+// request, see frameOrigin.
+func (s *Server) update(frame Framer, fn func(tables SlaveTables) error) error {
+	id := frame.Addr()
+	if slaver, ok := s.Slaver.(masterTablesSlaver); ok {
+		masterFn := fn
+		fn = func(tables SlaveTables) error {
+			return masterFn(slaver.masterTables(id, tables))
+		}
+	}
+	if updater, ok := s.Slaver.(SlaveOriginUpdater); ok {
+		return updater.UpdateFrom(id, frameOrigin(frame), fn)
+	}
+	return updateSlaver(s.Slaver, id, fn)
+}
+
+// origin describes the master of a request: the remote address of its
+// connection or datagram, followed by the role of an mbaps connection in
+// parentheses. It is empty on a serial line.
+func (request *Request) origin() string {
+	switch conn := request.conn.(type) {
+	case *serverConn:
+		return conn.origin
+	case *packetConn:
+		return conn.addr.String()
+	}
+	return ""
+}
+
+// handle processes a request and returns the response, nil if none is to be sent.
 func (s *Server) handle(request *Request) Framer {
 	var exception *Exception
 	var data []byte
 
+	if !s.receive(request.frame) {
+		return nil
+	}
+	setFrameOrigin(request.frame, request.origin())
 	response := request.frame.Copy()
 
 	function := request.frame.GetFunction()
-	if s.function[function] != nil {
-		request.frame.Bytes()
-		data, exception = s.function[function](s, request.frame)
+	if denied := s.authorize(request); denied != nil {
+		exception = denied
+	} else if s.function[function] != nil {
+		data, exception = s.call(function, request.frame)
 		response.SetData(data)
 	} else {
 		exception = &IllegalFunction
 	}
 
+	if !s.respond(request.frame, exception) {
+		return nil
+	}
+
 	if exception != &Success {
 		response.SetException(exception)
 	}
@@ -91,25 +221,218 @@ func (s *Server) handle(request *Request) Framer {
 	return response
 }
 
-// All requests are handled synchronously to prevent modbus memory corruption.
-func (s *Server) handler() {
-	for {
-		request := <-s.requestChan
-		response := s.handle(request)
-		request.conn.Write(response.Bytes())
+// authorize returns the exception of a request denied by the role policy of
+// an mbaps connection, nil if it is allowed.
+func (s *Server) authorize(request *Request) *Exception {
+	conn, ok := request.conn.(*serverConn)
+	if !ok || conn.policy == nil {
+		return nil
 	}
+	exception := conn.policy.authorize(conn.role, request.frame)
+	if exception != nil {
+		log.Printf("denied function %d of role %q from %s\n", request.frame.GetFunction(), conn.role, conn.RemoteAddr())
+	}
+	return exception
 }
 
-// Close stops listening to TCP/IP ports and closes serial ports.
-func (s *Server) Close() {
-	for _, listen := range s.listeners {
-		listen.Close()
+// call runs the handler of function, a panic of the handler is logged and
+// answered with SlaveDeviceFailure, so a malformed request can not stop the server.
+func (s *Server) call(function uint8, frame Framer) (data []byte, exception *Exception) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("function %d handler panic: %v, request frame 0x: % x\n%s", function, r, frame.Bytes(), debug.Stack())
+			data, exception = []byte{}, &SlaveDeviceFailure
+		}
+	}()
+	return s.function[function](s, frame)
+}
+
+// readFunctions are the functions that do not change the slave's data, their
+// requests run in parallel under ConcurrentDispatch.
+var readFunctions = [256]bool{1: true, 2: true, 3: true, 4: true, 7: true, 11: true, 12: true, 17: true, 20: true, 24: true, 43: true}
+
+// dispatch handles a request and writes its response. Requests are handled one
+// at a time for the whole server, or under ConcurrentDispatch one at a time
+// per slave except reads, and dispatch returns once the response is written,
+// so the caller sends the responses of a connection in order.
+func (s *Server) dispatch(request *Request) {
+	if response := s.handleLocked(request); response != nil {
+		s.write(request, response)
+	}
+}
+
+// handleLocked handles a request under the lock of the server, or of the slave
+// under ConcurrentDispatch. The response is written after, so a slow reader
+// does not hold the lock.
+func (s *Server) handleLocked(request *Request) Framer {
+	if !s.ConcurrentDispatch {
+		// All requests are handled synchronously to prevent modbus memory corruption.
+		s.handleLock.Lock()
+		defer s.handleLock.Unlock()
+		return s.handle(request)
+	}
+
+	lock := &s.slaveLocks[request.frame.Addr()]
+	if readFunctions[request.frame.GetFunction()] {
+		lock.RLock()
+		defer lock.RUnlock()
+	} else {
+		lock.Lock()
+		defer lock.Unlock()
 	}
+	return s.handle(request)
+}
 
-	close(s.portsCloseChan)
+// ErrServerClosed is returned by the Listen methods after Shutdown or Close.
+var ErrServerClosed = errors.New("mbserver: Server closed")
+
+// startServing calls register under the lock, then counts a goroutine of the
+// server for Shutdown, which calls serveWG.Done once it returns. It returns
+// ErrServerClosed if the server is shut down, or the error of register.
+func (s *Server) startServing(register func() error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closing {
+		return ErrServerClosed
+	}
+	if register != nil {
+		if err := register(); err != nil {
+			return err
+		}
+	}
+	s.serveWG.Add(1)
+	return nil
+}
+
+// serve calls register under the lock, then runs fn in a goroutine counted by
+// Shutdown. It returns ErrServerClosed if the server is shut down, or the error of register.
+func (s *Server) serve(register func() error, fn func()) error {
+	if err := s.startServing(register); err != nil {
+		return err
+	}
+	go func() {
+		defer s.serveWG.Done()
+		fn()
+	}()
+	return nil
+}
+
+// serveError reports the error of a listener that stopped serving to Run.
+func (s *Server) serveError(err error) {
+	if err == nil {
+		return
+	}
+	select {
+	case s.serveErrs <- err:
+	default:
+	}
+}
+
+// isClosing reports whether the server is shutting down.
+func (s *Server) isClosing() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.closing
+}
+
+// untrackPort removes a port closed by its goroutine.
+func (s *Server) untrackPort(port io.ReadWriteCloser) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i := range s.ports {
+		if s.ports[i] == port {
+			s.ports = append(s.ports[:i], s.ports[i+1:]...)
+			return
+		}
+	}
+}
+
+// stop stops accepting connections, packets and serial frames and closes the
+// idle connections, or all of them if force. It reports whether it was the first call.
+func (s *Server) stop(force bool) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	first := !s.closing
+	if first {
+		s.closing = true
+		for _, listen := range s.listeners {
+			listen.Close()
+		}
+		// The in-flight packets are still answered, the sockets close once served.
+		for _, conn := range s.packetConns {
+			conn.SetReadDeadline(time.Now())
+		}
+		close(s.portsCloseChan)
+	}
+	for conn := range s.conns {
+		if force {
+			s.closeConn(conn)
+		} else if conn.idle {
+			s.closeIdleConn(conn)
+		}
+	}
+	if force {
+		for _, conn := range s.packetConns {
+			conn.Close()
+		}
+	}
+	return first
+}
+
+// closePorts closes the UDP sockets and the serial ports once their goroutines are done.
+func (s *Server) closePorts() {
 	s.portsWG.Wait()
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, conn := range s.packetConns {
+		conn.Close()
+	}
 	for _, port := range s.ports {
 		port.Close()
 	}
+	s.packetConns, s.ports = nil, nil
+}
+
+// Shutdown gracefully shuts down the server, like net/http.Server.Shutdown: it
+// stops listening, closes the idle connections, waits for the in-flight
+// requests to be answered and for every goroutine of the server to return,
+// then closes the UDP sockets and serial ports. If ctx is done first, the
+// remaining connections are closed and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop(false)
+
+	served := make(chan struct{})
+	go func() {
+		s.serveWG.Wait()
+		close(served)
+	}()
+	select {
+	case <-served:
+		s.closePorts()
+		return nil
+	case <-ctx.Done():
+		s.Close()
+		return ctx.Err()
+	}
+}
+
+// Run serves until ctx is done or a listener fails, then shuts the server
+// down, see Shutdown. It returns the error of the failed listener, else nil.
+func (s *Server) Run(ctx context.Context) (err error) {
+	select {
+	case <-ctx.Done():
+	case err = <-s.serveErrs:
+	}
+	s.Shutdown(context.Background())
+	return err
+}
+
+// Close stops listening to TCP/IP ports, UDP ports and closes serial ports and
+// the connections at once, without waiting for the in-flight requests.
+// It can be called more than once, and after Shutdown.
+func (s *Server) Close() {
+	s.stop(true)
+	s.closePorts()
 }
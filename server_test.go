@@ -1,10 +1,14 @@
 package mbserver
 
 import (
+	"context"
+	"io"
+	"net"
 	"testing"
 	"time"
 
 	"github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
 )
 
 func TestAduRegisterAndNumber(t *testing.T) {
@@ -58,6 +62,7 @@ func TestModbus(t *testing.T) {
 
 	// Client
 	handler := modbus.NewTCPClientHandler("127.0.0.1:3333")
+	handler.SlaveId = 1
 	// Connect manually so that multiple requests are handled in one connection session
 	err = handler.Connect()
 	if err != nil {
@@ -124,6 +129,28 @@ func TestModbus(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 
+	results, err = client.MaskWriteRegister(2, 0xfff0, 0x0005)
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+		t.FailNow()
+	}
+	expect = []byte{0xff, 0xf0, 0x00, 0x05}
+	got = results
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
+	results, err = client.ReadWriteMultipleRegisters(0, 4, 2, 1, []byte{0, 5})
+	if err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+		t.FailNow()
+	}
+	expect = []byte{0, 0, 0, 3, 0, 5, 0, 0}
+	got = results
+	if !isEqual(expect, got) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+
 	// Input registers
 	inputRegisters, err := s.InputRegisters(1)
 	if err != nil {
@@ -147,3 +174,651 @@ func TestModbus(t *testing.T) {
 		t.Errorf("expected %v, got %v", expect, got)
 	}
 }
+
+func TestModbusTCPPipelined(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	addr := getFreePort()
+	err := s.ListenTCP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	// Two write requests in one segment, followed by a read request split in two.
+	requests := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x02, 0x00, 0x04,
+		0x00, 0x03, 0x00, 0x00, 0x00,
+	}
+	if _, err = conn.Write(requests); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err = conn.Write([]byte{0x06, 0x01, 0x03, 0x00, 0x01, 0x00, 0x02}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	expect := []byte{
+		0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03,
+		0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x02, 0x00, 0x04,
+		0x00, 0x03, 0x00, 0x00, 0x00, 0x07, 0x01, 0x03, 0x04, 0x00, 0x03, 0x00, 0x04,
+	}
+	got := make([]byte, len(expect))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected % x, got % x", expect, got)
+	}
+}
+
+// The request of Report Server ID is the function code alone.
+func TestModbusTCPReportServerId(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	client, served := serveConnPipe(s)
+
+	client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x02, 0x01, 0x11}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	expect := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x01, 0x11, 0x02, 0x01, 0xFF}
+	got := make([]byte, len(expect))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected % x, got % x", expect, got)
+	}
+
+	client.Close()
+	if err := <-served; err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+}
+
+func TestModbusRTUOverTCP(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	addr := getFreePort()
+	err := s.ListenRTUOverTCP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	// A frame with a bad CRC is dropped without closing the connection.
+	requests := append(rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x02)[:7], 0x00)
+	requests = append(requests, rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03)...)
+	requests = append(requests, rtuPacket(1, 3, 0x00, 0x01, 0x00, 0x01)...)
+	if _, err = conn.Write(requests); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	expect := append(rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03), rtuPacket(1, 3, 0x02, 0x00, 0x03)...)
+	got := make([]byte, len(expect))
+	if _, err = io.ReadFull(conn, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected % x, got % x", expect, got)
+	}
+}
+
+func TestModbusRTUOverUDP(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	addr := getFreePort()
+	err := s.ListenRTUOverUDP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	for _, request := range [][]byte{
+		rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03),
+		rtuPacket(1, 3, 0x00, 0x01, 0x00, 0x01),
+	} {
+		if _, err = conn.Write(request); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+	}
+
+	for _, expect := range [][]byte{
+		rtuPacket(1, 6, 0x00, 0x01, 0x00, 0x03),
+		rtuPacket(1, 3, 0x02, 0x00, 0x03),
+	} {
+		got := make([]byte, 512)
+		n, err := conn.Read(got)
+		if err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if !isEqual(expect, got[:n]) {
+			t.Errorf("expected % x, got % x", expect, got[:n])
+		}
+	}
+}
+
+func TestModbusUDP(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	addr := getFreePort()
+	err := s.ListenUDP(addr)
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	request := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03}
+	if _, err = conn.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	got := make([]byte, 512)
+	n, err := conn.Read(got)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(request, got[:n]) {
+		t.Errorf("expected % x, got % x", request, got[:n])
+	}
+
+	// Close releases the UDP port.
+	s.Close()
+	reuse, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	reuse.Close()
+}
+
+func TestModbusConcurrentDispatch(t *testing.T) {
+	// Hide the SlaveUpdater of the slave, so Mask Write Register relies on
+	// the server's lock of the slave.
+	s := NewServer(struct{ Slaver }{NewMemorySlaveUint8(2)})
+	s.ConcurrentDispatch = true
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	defer s.Close()
+
+	// Every client of a slave sets and clears its own bit of register 0.
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		go func(i int) {
+			handler := modbus.NewTCPClientHandler(addr)
+			handler.SlaveId = byte(i%2 + 1)
+			if err := handler.Connect(); err != nil {
+				errs <- err
+				return
+			}
+			defer handler.Close()
+			client := modbus.NewClient(handler)
+
+			bit := uint16(1) << uint(i/2)
+			for j := 0; j < 20; j++ {
+				if _, err := client.MaskWriteRegister(0, ^bit, 0); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := client.MaskWriteRegister(0, ^bit, bit); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(i)
+	}
+	for i := 0; i < 16; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+	}
+
+	for id := uint8(1); id <= 2; id++ {
+		holdingRegisters, _ := s.HoldingRegisters(id)
+		if holdingRegisters[0] != 0xFF {
+			t.Errorf("slave %d: expected 0xFF, got 0x%X", id, holdingRegisters[0])
+		}
+	}
+}
+
+// blockingHandler answers function 3 once release is closed, it signals started first.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) func(*Server, Framer) ([]byte, *Exception) {
+	return func(s *Server, frame Framer) ([]byte, *Exception) {
+		started <- struct{}{}
+		<-release
+		return ReadHoldingRegisters(s, frame)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	started, release := make(chan struct{}), make(chan struct{})
+	s.RegisterFunctionHandler(3, blockingHandler(started, release))
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	busy, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer busy.Close()
+	idle, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer idle.Close()
+
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	if _, err = busy.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	<-started
+
+	shutdown := make(chan error)
+	go func() { shutdown <- s.Shutdown(context.Background()) }()
+
+	// The idle connection is closed, the in-flight request holds the shutdown.
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n", err)
+	}
+	select {
+	case err = <-shutdown:
+		t.Fatalf("Shutdown returned %v before the in-flight request was answered", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	busy.SetReadDeadline(time.Now().Add(time.Second))
+	expect := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x05, 0x01, 0x03, 0x02, 0x00, 0x00}
+	got := make([]byte, len(expect))
+	if _, err = io.ReadFull(busy, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(expect, got) {
+		t.Errorf("expected % x, got % x", expect, got)
+	}
+	if err = <-shutdown; err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+
+	if _, err = net.Dial("tcp", addr); err == nil {
+		t.Errorf("expected the listener to be closed")
+	}
+	if err = s.ListenTCP(getFreePort()); err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v\n", err)
+	}
+	// Close after Shutdown, and twice, does nothing.
+	s.Close()
+	s.Close()
+}
+
+// readHookConn calls onRead once the first time a read returns data.
+type readHookConn struct {
+	net.Conn
+	onRead func()
+}
+
+func (c *readHookConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.onRead != nil {
+		c.onRead()
+		c.onRead = nil
+	}
+	return n, err
+}
+
+func TestServerShutdownAnswersReadRequest(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	client, server := net.Pipe()
+	defer client.Close()
+	// The server shuts down after the request is read, before it is handled.
+	conn := &readHookConn{Conn: server, onRead: func() { s.stop(false) }}
+	served := make(chan error, 1)
+	go func() { served <- s.ServeConn(conn) }()
+
+	writeSingleRegister(t, client)
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v\n", err)
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n", err)
+	}
+}
+
+func TestServerShutdownDeadline(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	s.RegisterFunctionHandler(3, blockingHandler(started, release))
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v\n", err)
+	}
+	// The connection of the stuck request is closed.
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n", err)
+	}
+}
+
+func TestServerRun(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	if err := s.ListenUDP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.SlaveId = 1
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	if _, err := modbus.NewClient(handler).ReadHoldingRegisters(0, 1); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected nil, got %v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Run did not return")
+	}
+	// The UDP port is released.
+	reuse, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	reuse.Close()
+}
+
+func TestListenRTUError(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	if err := s.ListenRTU(&serial.Config{Address: "/dev/mbserver-does-not-exist"}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+	served := make(chan error)
+	go func() { served <- s.Serve(listen) }()
+
+	handler := modbus.NewTCPClientHandler(listen.Addr().String())
+	handler.SlaveId = 1
+	if err = handler.Connect(); err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer handler.Close()
+	if _, err = modbus.NewClient(handler).WriteSingleRegister(1, 3); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if err = <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v\n", err)
+	}
+}
+
+func TestServeConn(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	client, server := net.Pipe()
+	served := make(chan error)
+	go func() { served <- s.ServeConn(server) }()
+
+	request := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03}
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	got := make([]byte, len(request))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(request, got) {
+		t.Errorf("expected % x, got % x", request, got)
+	}
+
+	client.Close()
+	if err := <-served; err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+}
+
+func TestServeRTU(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	client, server := net.Pipe()
+	defer client.Close()
+	served := make(chan error)
+	go func() { served <- s.ServeRTU(server) }()
+
+	request := (&RTUFrame{Address: 1, Function: 6, Data: []byte{0x00, 0x01, 0x00, 0x03}}).Bytes()
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	got := make([]byte, len(request))
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(request, got) {
+		t.Errorf("expected % x, got % x", request, got)
+	}
+	if counters := s.DiagnosticCounters(1); counters.ServerMessages != 1 {
+		t.Errorf("expected 1 server message, got %d", counters.ServerMessages)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v\n", err)
+	}
+	// ServeRTU closed the pipe.
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v\n", err)
+	}
+	if err := s.ServeRTU(server); err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v\n", err)
+	}
+}
+
+// serveConnPipe serves one end of a pipe with ServeConn, the error of ServeConn is sent to served.
+func serveConnPipe(s *Server) (client net.Conn, served chan error) {
+	client, server := net.Pipe()
+	served = make(chan error, 1)
+	go func() { served <- s.ServeConn(server) }()
+	return client, served
+}
+
+// writeSingleRegister writes register 1 through client and checks the echo.
+func writeSingleRegister(t *testing.T, client net.Conn) {
+	t.Helper()
+	request := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03}
+	client.SetDeadline(time.Now().Add(time.Second))
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	got := make([]byte, len(request))
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if !isEqual(request, got) {
+		t.Errorf("expected % x, got % x", request, got)
+	}
+}
+
+// waitConnections waits for n connections of s.
+func waitConnections(t *testing.T, s *Server, n int) []ConnectionInfo {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if infos := s.Connections(); len(infos) == n {
+			return infos
+		}
+	}
+	t.Fatalf("expected %d connections, got %d", n, len(s.Connections()))
+	return nil
+}
+
+func TestMaxConnections(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	s.MaxConnections = 2
+	// The reads of both connections run at once.
+	s.ConcurrentDispatch = true
+
+	oldest, oldestServed := serveConnPipe(s)
+	defer oldest.Close()
+	waitConnections(t, s, 1)
+	newer, newerServed := serveConnPipe(s)
+	defer newer.Close()
+	waitConnections(t, s, 2)
+	// The oldest connection has not sent a request for the longest time.
+	writeSingleRegister(t, newer)
+	writeSingleRegister(t, oldest)
+	writeSingleRegister(t, newer)
+
+	client, _ := serveConnPipe(s)
+	defer client.Close()
+	if err := <-oldestServed; err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+	oldest.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := oldest.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected %v, got %v\n", io.EOF, err)
+	}
+
+	infos := waitConnections(t, s, 2)
+	if infos[0].Requests != 2 || infos[1].Requests != 0 {
+		t.Errorf("expected 2 and 0 requests, got %d and %d", infos[0].Requests, infos[1].Requests)
+	}
+	if infos[0].Uptime < infos[1].Uptime || infos[0].LastRequest.Before(infos[0].Started) {
+		t.Errorf("unexpected connections %+v", infos)
+	}
+	writeSingleRegister(t, client)
+
+	// No connection is idle while the handlers run.
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s.RegisterFunctionHandler(3, blockingHandler(started, release))
+	request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}
+	for _, conn := range []net.Conn{newer, client} {
+		go conn.Write(request)
+	}
+	<-started
+	<-started
+	_, rejected := serveConnPipe(s)
+	if err := <-rejected; err != ErrTooManyConnections {
+		t.Errorf("expected %v, got %v\n", ErrTooManyConnections, err)
+	}
+	close(release)
+	newer.Close()
+	if err := <-newerServed; err != nil {
+		t.Errorf("expected nil, got %v\n", err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	s.IdleTimeout = 50 * time.Millisecond
+
+	client, served := serveConnPipe(s)
+	defer client.Close()
+	writeSingleRegister(t, client)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected nil, got %v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the idle connection to close")
+	}
+	waitConnections(t, s, 0)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	s := NewServer(NewMemorySlaveUint8(1))
+	defer s.Close()
+	s.WriteTimeout = 50 * time.Millisecond
+
+	client, served := serveConnPipe(s)
+	defer client.Close()
+	// The client does not read the response.
+	request := []byte{0x00, 0x07, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, 0x03}
+	if _, err := client.Write(request); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected nil, got %v\n", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the slow connection to close")
+	}
+}
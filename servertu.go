@@ -13,56 +13,122 @@ import (
 func (s *Server) ListenRTU(serialConfig *serial.Config) (err error) {
 	port, err := serial.Open(serialConfig)
 	if err != nil {
-		log.Fatalf("failed to open %s: %s\n", serialConfig.Address, errors.WithStack(err).Error())
+		err = errors.WithStack(err)
+		log.Printf("failed to open %s: %s\n", serialConfig.Address, err.Error())
+		return err
 	}
-	s.ports = append(s.ports, port)
 
-	s.portsWG.Add(1)
-	go func() {
+	return s.servePort(port, func() packetReader {
+		return newRTUReader(port, rtuSilentInterval(serialConfig.BaudRate), s.portsCloseChan)
+	}, newRTUFramer)
+}
+
+// servePort serves the frames of a serial port until the server shuts down,
+// the packetReader of newReader must stop reading once portsCloseChan is closed.
+func (s *Server) servePort(port io.ReadWriteCloser, newReader func() packetReader, newFrame func([]byte) (Framer, error)) error {
+	err := s.serve(func() error {
+		s.ports = append(s.ports, port)
+		s.portsWG.Add(1)
+		return nil
+	}, func() {
 		defer s.portsWG.Done()
-		s.acceptSerialRequests(port)
-	}()
+		s.serveError(s.acceptSerialRequests(port, newReader(), newFrame))
+	})
+	if err != nil {
+		port.Close()
+	}
+	return err
+}
 
+// ServeRTU answers the RTU frames of rwc, like a serial port of ListenRTU,
+// until it returns EOF or the server shuts down, then closes it. rwc can be a
+// PTY, a pipe or a tunnel, the end of a frame of unknown length is detected
+// after the silent interval of a network connection.
+// It returns nil on EOF, ErrServerClosed after Shutdown or Close.
+func (s *Server) ServeRTU(rwc io.ReadWriteCloser) error {
+	err := s.startServing(func() error {
+		s.ports = append(s.ports, rwc)
+		s.portsWG.Add(1)
+		return nil
+	})
+	if err != nil {
+		rwc.Close()
+		return err
+	}
+	defer s.serveWG.Done()
+
+	err = s.acceptSerialRequests(rwc, newRTUReader(rwc, rtuNetworkSilentInterval, s.portsCloseChan), newRTUFramer)
+	s.untrackPort(rwc)
+	s.portsWG.Done()
+	rwc.Close()
+	if s.isClosing() {
+		return ErrServerClosed
+	}
 	return err
 }
 
-func (s *Server) acceptSerialRequests(port serial.Port) {
+func newRTUFramer(packet []byte) (Framer, error) {
+	frame, err := NewRTUFrame(packet)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// acceptSerialRequests answers the frames of port until the server shuts
+// down, it returns the read error of port, nil on EOF.
+func (s *Server) acceptSerialRequests(port io.ReadWriteCloser, reader packetReader, newFrame func([]byte) (Framer, error)) error {
 SkipFrameError:
 	for {
 		select {
 		case <-s.portsCloseChan:
-			return
+			return nil
 		default:
 		}
 
-		buffer := make([]byte, 512)
-
-		bytesRead, err := port.Read(buffer)
+		packet, err := reader.ReadPacket()
 		if err != nil {
-			if err != io.EOF {
-				log.Printf("serial read error %s\n", errors.WithStack(err).Error())
+			if err == io.EOF || s.isClosing() {
+				return nil
 			}
-			return
+			err = errors.WithStack(err)
+			log.Printf("serial read error %s\n", err.Error())
+			return err
 		}
 
-		if bytesRead != 0 {
+		frame, err := newFrame(packet)
+		s.countBusMessage(err != nil)
+		if err != nil {
+			log.Printf("bad serial frame error %s\n", err.Error())
+			//The next line prevents RTU server from exiting when it receives a bad frame. Simply discard the erroneous
+			//frame and wait for next frame by jumping back to the beginning of the 'for' loop.
+			log.Printf("Keep the RTU server running!!\n")
+			continue SkipFrameError
+			//return
+		}
 
-			// Set the length of the packet to the number of read bytes.
-			packet := buffer[:bytesRead]
+		request := &Request{port, frame}
 
-			frame, err := NewRTUFrame(packet)
-			if err != nil {
-				log.Printf("bad serial frame error %s\n", err.Error())
-				//The next line prevents RTU server from exiting when it receives a bad frame. Simply discard the erroneous
-				//frame and wait for next frame by jumping back to the beginning of the 'for' loop.
-				log.Printf("Keep the RTU server running!!\n")
-				continue SkipFrameError
-				//return
-			}
+		s.dispatch(request)
+	}
+}
 
-			request := &Request{port, frame}
+// serialReader reads from a serial port, retrying reads that time out while
+// the line is idle until done is closed.
+type serialReader struct {
+	port serial.Port
+	done <-chan struct{}
+}
 
-			s.requestChan <- request
+func (r serialReader) Read(b []byte) (n int, err error) {
+	for {
+		if n, err = r.port.Read(b); err != serial.ErrTimeout {
+			return n, err
+		}
+		select {
+		case <-r.done:
+			return 0, io.EOF
+		default:
 		}
 	}
 }
@@ -5,16 +5,41 @@ import (
 	"io"
 	"log"
 	"net"
-	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-func (s *Server) accept(listen net.Listener) error {
+func newTCPFramer(packet []byte) (Framer, error) {
+	frame, err := NewTCPFrame(packet)
+	if err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// serveListener serves the connections of listen until the server shuts down.
+func (s *Server) serveListener(listen net.Listener, newReader func(r io.Reader, done <-chan struct{}) packetReader, newFrame func([]byte) (Framer, error)) error {
+	err := s.serve(func() error {
+		s.listeners = append(s.listeners, listen)
+		return nil
+	}, func() {
+		s.serveError(s.accept(listen, newReader, newFrame))
+	})
+	if err != nil {
+		listen.Close()
+	}
+	return err
+}
+
+// accept serves the connections of listen. newReader splits the byte stream of
+// a connection into packets, it must stop reading once done is closed.
+// It returns nil once the server shuts down.
+func (s *Server) accept(listen net.Listener, newReader func(r io.Reader, done <-chan struct{}) packetReader, newFrame func([]byte) (Framer, error)) error {
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
-			if strings.Contains(err.Error(), "use of closed network connection") {
+			if s.isClosing() {
 				return nil
 			}
 			err = errors.WithStack(err)
@@ -22,35 +47,127 @@ func (s *Server) accept(listen net.Listener) error {
 			return err
 		}
 
-		go func(conn net.Conn) {
-			defer conn.Close()
-
-			for {
-				packet := make([]byte, 512)
-				bytesRead, err := conn.Read(packet)
-				if err != nil {
-					if err != io.EOF {
-						log.Printf("read error: %s\n", errors.WithStack(err).Error())
-					}
-					return
-				}
-				// Set the length of the packet to the number of read bytes.
-				packet = packet[:bytesRead]
+		c := s.newServerConn(conn)
+		if listen, ok := listen.(*mbapsListener); ok {
+			c.policy = listen.policy
+		}
+		err = s.serve(func() error { return s.trackConn(c) }, func() {
+			defer s.untrackConn(c)
+			s.serveConn(c, newReader, newFrame)
+		})
+		if err == ErrTooManyConnections {
+			log.Printf("rejected connection from %s: %s\n", conn.RemoteAddr(), err.Error())
+			conn.Close()
+			continue
+		}
+		if err != nil {
+			conn.Close()
+			return nil
+		}
+	}
+}
 
-				frame, err := NewTCPFrame(packet)
-				if err != nil {
-					log.Printf("bad packet error %s\n", err.Error())
-					return
-				}
+// serveConn answers the requests of conn until it is closed, idle for
+// IdleTimeout or the server shuts down, then closes it. It returns the read
+// error of conn, nil on EOF.
+func (s *Server) serveConn(conn *serverConn, newReader func(r io.Reader, done <-chan struct{}) packetReader, newFrame func([]byte) (Framer, error)) error {
+	defer conn.Close()
 
-				request := &Request{conn, frame}
+	done := make(chan struct{})
+	defer close(done)
 
-				s.requestChan <- request
+	if conn.policy != nil {
+		// The connection is idle during the handshake, it can be closed for a new
+		// one or by Shutdown, and authenticate bounds it by HandshakeTimeout.
+		if !s.setConnIdle(conn, true) {
+			return nil
+		}
+		if err := s.authenticate(conn); err != nil {
+			log.Printf("mbaps connection from %s refused: %s\n", conn.RemoteAddr(), err.Error())
+			return nil
+		}
+	}
+
+	reader := newReader(conn, done)
+	for {
+		if !s.setConnIdle(conn, true) {
+			return nil
+		}
+		packet, err := reader.ReadPacket()
+		if err != nil {
+			if err == io.EOF || s.isConnClosed(conn) {
+				return nil
 			}
-		}(conn)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				if s.Debug {
+					log.Printf("closing idle connection from %s\n", conn.RemoteAddr())
+				}
+				return nil
+			}
+			err = errors.WithStack(err)
+			log.Printf("read error: %s\n", err.Error())
+			return err
+		}
+		// A connection closed while the request was read still answers it, then
+		// returns as the next setConnIdle reports it closed.
+		s.setConnIdle(conn, false)
+
+		frame, err := newFrame(packet)
+		if err != nil {
+			log.Printf("bad packet error %s\n", err.Error())
+			continue
+		}
+
+		request := &Request{conn, frame}
+
+		s.dispatch(request)
 	}
 }
 
+// Serve accepts the Modbus TCP connections of listen, like ListenTCP, until
+// the server shuts down or listen fails, so the listener can come from systemd
+// socket activation or a custom transport. The server closes listen on
+// Shutdown or Close, then Serve returns ErrServerClosed.
+func (s *Server) Serve(listen net.Listener) error {
+	err := s.startServing(func() error {
+		s.listeners = append(s.listeners, listen)
+		return nil
+	})
+	if err != nil {
+		listen.Close()
+		return err
+	}
+	defer s.serveWG.Done()
+
+	if err := s.accept(listen, newTCPPacketReader, newTCPFramer); err != nil {
+		return err
+	}
+	return ErrServerClosed
+}
+
+// ServeConn answers the Modbus TCP requests of conn, like a connection of
+// ListenTCP, until it is closed or the server shuts down, then closes it.
+// It returns nil on EOF, ErrServerClosed after Shutdown or Close, and
+// ErrTooManyConnections if no connection could be closed for it, see MaxConnections.
+func (s *Server) ServeConn(conn net.Conn) error {
+	c := s.newServerConn(conn)
+	if err := s.startServing(func() error { return s.trackConn(c) }); err != nil {
+		conn.Close()
+		return err
+	}
+	defer s.serveWG.Done()
+	defer s.untrackConn(c)
+
+	if err := s.serveConn(c, newTCPPacketReader, newTCPFramer); err != nil {
+		return err
+	}
+	if s.isClosing() {
+		return ErrServerClosed
+	}
+	return nil
+}
+
 // ListenTCP starts the Modbus server listening on "address:port".
 func (s *Server) ListenTCP(addressPort string) (err error) {
 	listen, err := net.Listen("tcp", addressPort)
@@ -59,9 +176,7 @@ func (s *Server) ListenTCP(addressPort string) (err error) {
 		log.Printf("Failed to Listen: %s\n", err.Error())
 		return err
 	}
-	s.listeners = append(s.listeners, listen)
-	go s.accept(listen)
-	return err
+	return s.serveListener(listen, newTCPPacketReader, newTCPFramer)
 }
 
 // ListenTLS starts the Modbus server listening on "address:port".
@@ -72,7 +187,30 @@ func (s *Server) ListenTLS(addressPort string, config *tls.Config) (err error) {
 		log.Printf("Failed to Listen on TLS: %s\n", err.Error())
 		return err
 	}
-	s.listeners = append(s.listeners, listen)
-	go s.accept(listen)
-	return err
+	return s.serveListener(listen, newTCPPacketReader, newTCPFramer)
+}
+
+// rtuNetworkSilentInterval ends RTU frames of unknown length on network
+// connections, where the serial line timing is lost.
+const rtuNetworkSilentInterval = 20 * time.Millisecond
+
+func newTCPPacketReader(r io.Reader, done <-chan struct{}) packetReader {
+	return newTCPReader(r)
+}
+
+func newRTUPacketReader(r io.Reader, done <-chan struct{}) packetReader {
+	return newRTUReader(r, rtuNetworkSilentInterval, done)
+}
+
+// ListenRTUOverTCP starts the Modbus server listening on "address:port" for
+// RTU frames (address, PDU and CRC) sent without the MBAP header, as used by
+// many serial to Ethernet converters.
+func (s *Server) ListenRTUOverTCP(addressPort string) (err error) {
+	listen, err := net.Listen("tcp", addressPort)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Printf("Failed to Listen: %s\n", err.Error())
+		return err
+	}
+	return s.serveListener(listen, newRTUPacketReader, newRTUFramer)
 }
@@ -0,0 +1,91 @@
+package mbserver
+
+import (
+	"io"
+	"log"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// packetConn answers a request received on a shared datagram socket by
+// sending the response back to the source address.
+type packetConn struct {
+	conn net.PacketConn
+	addr net.Addr
+}
+
+func (c *packetConn) Read(b []byte) (int, error) { return 0, io.EOF }
+
+func (c *packetConn) Write(b []byte) (int, error) { return c.conn.WriteTo(b, c.addr) }
+
+// Close does nothing, the socket is shared by all peers and closed by the server.
+func (c *packetConn) Close() error { return nil }
+
+// acceptPackets serves the datagrams of conn, each of them holds one frame.
+func (s *Server) acceptPackets(conn net.PacketConn, newFrame func([]byte) (Framer, error)) error {
+	for {
+		packet := make([]byte, 512)
+		bytesRead, addr, err := conn.ReadFrom(packet)
+		if err != nil {
+			if s.isClosing() {
+				return nil
+			}
+			err = errors.WithStack(err)
+			log.Printf("Unable to read packets: %s\n", err.Error())
+			return err
+		}
+		// Set the length of the packet to the number of read bytes.
+		packet = packet[:bytesRead]
+
+		frame, err := newFrame(packet)
+		if err != nil {
+			log.Printf("bad packet error %s\n", err.Error())
+			continue
+		}
+
+		request := &Request{&packetConn{conn, addr}, frame}
+
+		s.dispatch(request)
+	}
+}
+
+// servePackets serves the datagrams of conn until the server shuts down.
+func (s *Server) servePackets(conn net.PacketConn, newFrame func([]byte) (Framer, error)) error {
+	err := s.serve(func() error {
+		s.packetConns = append(s.packetConns, conn)
+		s.portsWG.Add(1)
+		return nil
+	}, func() {
+		defer s.portsWG.Done()
+		s.serveError(s.acceptPackets(conn, newFrame))
+	})
+	if err != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// ListenUDP starts the Modbus server listening on "address:port" for
+// datagrams that each carry one Modbus TCP ADU (MBAP header and PDU).
+func (s *Server) ListenUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Printf("Failed to Listen on UDP: %s\n", err.Error())
+		return err
+	}
+	return s.servePackets(conn, newTCPFramer)
+}
+
+// ListenRTUOverUDP starts the Modbus server listening on "address:port" for
+// datagrams that each carry one RTU frame (address, PDU and CRC).
+func (s *Server) ListenRTUOverUDP(addressPort string) (err error) {
+	conn, err := net.ListenPacket("udp", addressPort)
+	if err != nil {
+		err = errors.WithStack(err)
+		log.Printf("Failed to Listen on UDP: %s\n", err.Error())
+		return err
+	}
+	return s.servePackets(conn, newRTUFramer)
+}
@@ -0,0 +1,537 @@
+package mbserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Layout of a binary slave file: a header, then 65536 discrete inputs and
+// 65536 coils of one byte each, then 65536 holding registers and 65536 input
+// registers of two bytes each, big endian.
+const (
+	binarySlaveHeaderSize          = 64
+	binarySlaveDiscreteInputsStart = binarySlaveHeaderSize
+	binarySlaveCoilsStart          = binarySlaveDiscreteInputsStart + tableLength
+	binarySlaveHoldingStart        = binarySlaveCoilsStart + tableLength
+	binarySlaveInputStart          = binarySlaveHoldingStart + tableLength*2
+	binarySlaveFileSize            = binarySlaveInputStart + tableLength*2
+)
+
+// binarySlaveMagic starts the header of a binary slave file, the last byte is the layout version.
+var binarySlaveMagic = []byte("MBSLAVE\x01")
+
+// BinaryFileSlaver is a Slaver backed by open files. Sync flushes the
+// changes to disk, Close flushes them and closes the files.
+type BinaryFileSlaver interface {
+	Slaver
+	Sync() error
+	Close() error
+}
+
+// binarySlaveFile is the open file of a slave, memory mapped where supported.
+type binarySlaveFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Sync() error
+	Close() error
+}
+
+var _ Slaver = new(binaryFileSlaveUint8)
+var _ RangeSlaver = new(binaryFileSlaveUint8)
+var _ SlaveUpdater = new(binaryFileSlaveUint8)
+
+type binaryFileSlaveUint8 struct {
+	slaveNum  uint8
+	slaveLock []sync.RWMutex
+	files     []binarySlaveFile
+	// closed is set by Close holding all slave locks, so it is read under the lock of any slave.
+	closed bool
+}
+
+// errBinarySlaveClosed is returned by the calls to a binary file slave after Close.
+var errBinarySlaveClosed = errors.New("binary file slave closed")
+
+// will create slaveNum slaves, slave id is [1, slaveNum], slaveNumMax is 255, slaveNumMin is 1, slave id is stored in ${fileStoreDir}/${id}.slave, a fixed layout binary file that is memory mapped on linux; if fileStoreDir is "", will use "./file-slave"
+func NewBinaryFileSlaveUint8(slaveNum uint8, fileStoreDir string) (slaver BinaryFileSlaver, err error) {
+
+	if slaveNum < 1 {
+		slaveNum = 1
+	}
+	if fileStoreDir == "" {
+		fileStoreDir = "./file-slave"
+	}
+	if err = os.MkdirAll(fileStoreDir, 0755); err != nil {
+		err = errors.Wrap(err, "mkdir all fail")
+		return
+	}
+	var s = &binaryFileSlaveUint8{
+		slaveNum:  slaveNum,
+		slaveLock: make([]sync.RWMutex, slaveNum),
+		files:     make([]binarySlaveFile, slaveNum),
+	}
+	for i := range s.files {
+		if s.files[i], err = openBinarySlaveFile(fmt.Sprintf("%s/%d.slave", fileStoreDir, i+1)); err != nil {
+			s.files = s.files[:i]
+			s.Close()
+			return
+		}
+	}
+	slaver = s
+	return
+}
+
+// openBinarySlaveFile opens the file of a slave, creating it if it does not exist.
+func openBinarySlaveFile(filePath string) (slaveFile binarySlaveFile, err error) {
+
+	var file *os.File
+	if file, err = os.OpenFile(filePath, os.O_CREATE|os.O_RDWR, 0644); err != nil {
+		err = errors.Wrap(err, "open file fail")
+		return
+	}
+	var fileInfo os.FileInfo
+	if fileInfo, err = file.Stat(); err != nil {
+		file.Close()
+		err = errors.Wrap(err, "stat file fail")
+		return
+	}
+	if fileInfo.Size() == 0 {
+		if _, err = file.WriteAt(binarySlaveMagic, 0); err == nil {
+			err = file.Truncate(binarySlaveFileSize)
+		}
+		if err == nil {
+			err = file.Sync()
+		}
+		if err != nil {
+			file.Close()
+			err = errors.Wrap(err, "create file fail")
+			return
+		}
+	} else {
+		var magic = make([]byte, len(binarySlaveMagic))
+		if _, err = file.ReadAt(magic, 0); err != nil || fileInfo.Size() != binarySlaveFileSize || !bytes.Equal(magic, binarySlaveMagic) {
+			file.Close()
+			err = errors.Errorf("%s is not a binary slave file", filePath)
+			return
+		}
+	}
+	if slaveFile, err = mapBinarySlaveFile(file); err != nil {
+		file.Close()
+	}
+	return
+}
+
+func (s *binaryFileSlaveUint8) IsSlaveIdValid(id uint8) bool { return id > 0 && id <= s.slaveNum }
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) DiscreteInputs(id uint8) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.readBytes(binarySlaveDiscreteInputsStart, 0, tableLength)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) Coils(id uint8) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.readBytes(binarySlaveCoilsStart, 0, tableLength)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) HoldingRegisters(id uint8) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.readRegisters(binarySlaveHoldingStart, 0, tableLength)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) InputRegisters(id uint8) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.readRegisters(binarySlaveInputStart, 0, tableLength)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) SaveDiscreteInputs(id uint8, b []byte) (err error) {
+
+	// the entries past the end of b are zero, as in a table saved whole
+	var table = make([]byte, tableLength)
+	copy(table, b)
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.writeBytes(binarySlaveDiscreteInputsStart, 0, table)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) SaveCoils(id uint8, b []byte) (err error) {
+
+	// the entries past the end of b are zero, as in a table saved whole
+	var table = make([]byte, tableLength)
+	copy(table, b)
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.writeBytes(binarySlaveCoilsStart, 0, table)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) SaveHoldingRegisters(id uint8, b []uint16) (err error) {
+
+	// the entries past the end of b are zero, as in a table saved whole
+	var table = make([]uint16, tableLength)
+	copy(table, b)
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.writeRegisters(binarySlaveHoldingStart, 0, table)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) SaveInputRegisters(id uint8, b []uint16) (err error) {
+
+	// the entries past the end of b are zero, as in a table saved whole
+	var table = make([]uint16, tableLength)
+	copy(table, b)
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.writeRegisters(binarySlaveInputStart, 0, table)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) ReadDiscreteInputs(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.ReadDiscreteInputs(start, count)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) ReadCoils(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.ReadCoils(start, count)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) ReadHoldingRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.ReadHoldingRegisters(start, count)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) ReadInputRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	if err = s.checkOpen(); err == nil {
+		bs, err = binarySlaveTables{s.files[id]}.ReadInputRegisters(start, count)
+	}
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) WriteDiscreteInputs(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.WriteDiscreteInputs(start, b)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) WriteCoils(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.WriteCoils(start, b)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) WriteHoldingRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.WriteHoldingRegisters(start, b)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) WriteInputRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = binarySlaveTables{s.files[id]}.WriteInputRegisters(start, b)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *binaryFileSlaveUint8) Update(id uint8, fn func(tables SlaveTables) error) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	if err = s.checkOpen(); err == nil {
+		err = updateSlaveTables(binarySlaveTables{s.files[id]}, fn)
+	}
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// Sync flushes the changes of all slaves to disk.
+func (s *binaryFileSlaveUint8) Sync() (err error) {
+
+	for i, file := range s.files {
+		s.slaveLock[i].RLock()
+		if s.closed {
+			s.slaveLock[i].RUnlock()
+			return errBinarySlaveClosed
+		}
+		if syncErr := file.Sync(); syncErr != nil && err == nil {
+			err = errors.Wrapf(syncErr, "sync slave %d fail", i+1)
+		}
+		s.slaveLock[i].RUnlock()
+	}
+	return
+}
+
+// Close flushes the changes of all slaves to disk and closes their files, the
+// calls to the slaves after return an error. Closing again does nothing.
+func (s *binaryFileSlaveUint8) Close() (err error) {
+
+	// all the slaves are locked, so no call is using a file while it is unmapped
+	for i := range s.slaveLock {
+		s.slaveLock[i].Lock()
+	}
+	defer func() {
+		for i := range s.slaveLock {
+			s.slaveLock[i].Unlock()
+		}
+	}()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for i, file := range s.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = errors.Wrapf(closeErr, "close slave %d fail", i+1)
+		}
+	}
+	return
+}
+
+// checkOpen returns errBinarySlaveClosed after Close, the caller holds the lock of a slave.
+func (s *binaryFileSlaveUint8) checkOpen() error {
+	if s.closed {
+		return errBinarySlaveClosed
+	}
+	return nil
+}
+
+func (s *binaryFileSlaveUint8) getRealId(id uint8) (realId uint8) {
+
+	switch {
+	case id > s.slaveNum:
+		realId = s.slaveNum - 1
+	case id < 1:
+		realId = 0
+	default:
+		realId = id - 1
+	}
+	return
+}
+
+var _ SlaveTables = binarySlaveTables{}
+
+// binarySlaveTables are the tables in the file of a slave, the caller holds the slave's lock.
+type binarySlaveTables struct {
+	file binarySlaveFile
+}
+
+func (t binarySlaveTables) readBytes(tableStart int64, start int, count int) ([]byte, error) {
+	var bs = make([]byte, count)
+	if _, err := t.file.ReadAt(bs, tableStart+int64(start)); err != nil {
+		return nil, errors.Wrap(err, "read file fail")
+	}
+	return bs, nil
+}
+
+func (t binarySlaveTables) readRegisters(tableStart int64, start int, count int) ([]uint16, error) {
+	var bs, err = t.readBytes(tableStart, start*2, count*2)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToUint16(bs), nil
+}
+
+func (t binarySlaveTables) writeBytes(tableStart int64, start int, values []byte) error {
+	if _, err := t.file.WriteAt(values, tableStart+int64(start)); err != nil {
+		return errors.Wrap(err, "write file fail")
+	}
+	return nil
+}
+
+func (t binarySlaveTables) writeRegisters(tableStart int64, start int, values []uint16) error {
+	var bs = make([]byte, len(values)*2)
+	for i, value := range values {
+		binary.BigEndian.PutUint16(bs[i*2:], value)
+	}
+	return t.writeBytes(tableStart, start*2, bs)
+}
+
+func (t binarySlaveTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readBytes(binarySlaveDiscreteInputsStart, int(start), int(count))
+}
+
+func (t binarySlaveTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readBytes(binarySlaveCoilsStart, int(start), int(count))
+}
+
+func (t binarySlaveTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readRegisters(binarySlaveHoldingStart, int(start), int(count))
+}
+
+func (t binarySlaveTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readRegisters(binarySlaveInputStart, int(start), int(count))
+}
+
+func (t binarySlaveTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeBytes(binarySlaveDiscreteInputsStart, int(start), values)
+}
+
+func (t binarySlaveTables) WriteCoils(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeBytes(binarySlaveCoilsStart, int(start), values)
+}
+
+func (t binarySlaveTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeRegisters(binarySlaveHoldingStart, int(start), values)
+}
+
+func (t binarySlaveTables) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeRegisters(binarySlaveInputStart, int(start), values)
+}
+
+// ImportFileSlaveUint8 copies the tables of slaves 1 to slaveNum stored by
+// NewFileSlaveUint8 in fileStoreDir to slaver, to migrate the hex files to
+// another Slaver such as NewBinaryFileSlaveUint8.
+func ImportFileSlaveUint8(slaver Slaver, slaveNum uint8, fileStoreDir string) (err error) {
+
+	var fileSlaver = NewFileSlaveUint8(slaveNum, fileStoreDir)
+	for id := uint8(1); id <= slaveNum && id != 0; id++ {
+		var bs []byte
+		var registers []uint16
+		if bs, err = fileSlaver.DiscreteInputs(id); err == nil {
+			err = slaver.SaveDiscreteInputs(id, bs)
+		}
+		if err == nil {
+			if bs, err = fileSlaver.Coils(id); err == nil {
+				err = slaver.SaveCoils(id, bs)
+			}
+		}
+		if err == nil {
+			if registers, err = fileSlaver.HoldingRegisters(id); err == nil {
+				err = slaver.SaveHoldingRegisters(id, registers)
+			}
+		}
+		if err == nil {
+			if registers, err = fileSlaver.InputRegisters(id); err == nil {
+				err = slaver.SaveInputRegisters(id, registers)
+			}
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "import slave %d fail", id)
+			return
+		}
+	}
+	return
+}
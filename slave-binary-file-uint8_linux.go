@@ -0,0 +1,52 @@
+package mbserver
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// mmapSlaveFile is a slave file mapped in memory, reads and writes copy
+// from and to the mapping and only touch the pages of the entries.
+type mmapSlaveFile struct {
+	file *os.File
+	data []byte
+}
+
+func mapBinarySlaveFile(file *os.File) (binarySlaveFile, error) {
+	data, err := syscall.Mmap(int(file.Fd()), 0, binarySlaveFileSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrap(err, "mmap file fail")
+	}
+	return &mmapSlaveFile{file: file, data: data}, nil
+}
+
+func (f *mmapSlaveFile) ReadAt(p []byte, off int64) (int, error) {
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *mmapSlaveFile) WriteAt(p []byte, off int64) (int, error) {
+	return copy(f.data[off:], p), nil
+}
+
+// Sync writes the dirty pages of the mapping to disk.
+func (f *mmapSlaveFile) Sync() error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&f.data[0])), uintptr(len(f.data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errors.Wrap(errno, "msync file fail")
+	}
+	return nil
+}
+
+func (f *mmapSlaveFile) Close() error {
+	err := f.Sync()
+	if munmapErr := syscall.Munmap(f.data); munmapErr != nil && err == nil {
+		err = errors.Wrap(munmapErr, "munmap file fail")
+	}
+	if closeErr := f.file.Close(); closeErr != nil && err == nil {
+		err = errors.Wrap(closeErr, "close file fail")
+	}
+	return err
+}
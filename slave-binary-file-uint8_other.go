@@ -0,0 +1,13 @@
+//go:build !linux
+
+package mbserver
+
+import (
+	"os"
+)
+
+// Without memory mapping the slave file is read and written in place, reads
+// and writes still only touch the bytes of the entries.
+func mapBinarySlaveFile(file *os.File) (binarySlaveFile, error) {
+	return file, nil
+}
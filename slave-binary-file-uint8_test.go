@@ -0,0 +1,112 @@
+package mbserver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBinaryFileSlave(t *testing.T, slaveNum uint8, dir string) BinaryFileSlaver {
+	slaver, err := NewBinaryFileSlaveUint8(slaveNum, dir)
+	if err != nil {
+		t.Fatalf("NewBinaryFileSlaveUint8() error = %v", err)
+	}
+	t.Cleanup(func() { slaver.Close() })
+	return slaver
+}
+
+func Test_binaryFileSlaveUint8_Restart(t *testing.T) {
+	dir := t.TempDir()
+	slaver, err := NewBinaryFileSlaveUint8(2, dir)
+	if err != nil {
+		t.Fatalf("NewBinaryFileSlaveUint8() error = %v", err)
+	}
+	coils := make([]byte, 10)
+	coils[9] = 1
+	if err = slaver.SaveCoils(2, coils); err != nil {
+		t.Fatalf("SaveCoils() error = %v", err)
+	}
+	if err = slaver.(RangeSlaver).WriteInputRegisters(2, 65535, []uint16{0xBEEF}); err != nil {
+		t.Fatalf("WriteInputRegisters() error = %v", err)
+	}
+	if err = slaver.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	slaver = newTestBinaryFileSlave(t, 2, dir)
+	gotCoils, _ := slaver.Coils(2)
+	if len(gotCoils) != 65536 || gotCoils[9] != 1 || gotCoils[8] != 0 {
+		t.Errorf("Coils() = %v coils, want coil 9 on", len(gotCoils))
+	}
+	gotRegisters, _ := slaver.InputRegisters(2)
+	if len(gotRegisters) != 65536 || gotRegisters[65535] != 0xBEEF {
+		t.Errorf("InputRegisters() = %v registers, want 0xBEEF at 65535", len(gotRegisters))
+	}
+	gotRegisters, _ = slaver.InputRegisters(1)
+	if gotRegisters[65535] != 0 {
+		t.Errorf("InputRegisters() of slave 1 = %v at 65535, want 0", gotRegisters[65535])
+	}
+}
+
+func Test_binaryFileSlaveUint8_Close(t *testing.T) {
+	slaver, err := NewBinaryFileSlaveUint8(1, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBinaryFileSlaveUint8() error = %v", err)
+	}
+	if err = slaver.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	// The unmapped files are not touched after Close.
+	if err = slaver.Close(); err != nil {
+		t.Errorf("second Close() error = %v, want nil", err)
+	}
+	if _, err = slaver.HoldingRegisters(1); err != errBinarySlaveClosed {
+		t.Errorf("HoldingRegisters() error = %v, want %v", err, errBinarySlaveClosed)
+	}
+	if err = slaver.(RangeSlaver).WriteCoils(1, 0, []byte{1}); err != errBinarySlaveClosed {
+		t.Errorf("WriteCoils() error = %v, want %v", err, errBinarySlaveClosed)
+	}
+	if err = slaver.(SlaveUpdater).Update(1, func(SlaveTables) error { return nil }); err != errBinarySlaveClosed {
+		t.Errorf("Update() error = %v, want %v", err, errBinarySlaveClosed)
+	}
+	if err = slaver.Sync(); err != errBinarySlaveClosed {
+		t.Errorf("Sync() error = %v, want %v", err, errBinarySlaveClosed)
+	}
+}
+
+func Test_binaryFileSlaveUint8_NotASlaveFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "1.slave"), []byte("0000"), 0644)
+	if _, err := NewBinaryFileSlaveUint8(1, dir); err == nil {
+		t.Errorf("NewBinaryFileSlaveUint8() error = %v, want error", err)
+	}
+}
+
+func TestImportFileSlaveUint8(t *testing.T) {
+	hexDir := t.TempDir()
+	fileSlaver := NewFileSlaveUint8(2, hexDir)
+	fileSlaver.SaveHoldingRegisters(1, []uint16{1, 2, 3})
+	fileSlaver.SaveDiscreteInputs(2, []byte{0, 1})
+
+	slaver := newTestBinaryFileSlave(t, 2, t.TempDir())
+	if err := ImportFileSlaveUint8(slaver, 2, hexDir); err != nil {
+		t.Fatalf("ImportFileSlaveUint8() error = %v", err)
+	}
+	holdingRegisters, _ := slaver.HoldingRegisters(1)
+	if expect := []uint16{1, 2, 3, 0}; !isEqual(expect, holdingRegisters[:4]) {
+		t.Errorf("HoldingRegisters() = %v, want %v", holdingRegisters[:4], expect)
+	}
+	discreteInputs, _ := slaver.DiscreteInputs(2)
+	if expect := []byte{0, 1, 0}; !isEqual(expect, discreteInputs[:3]) {
+		t.Errorf("DiscreteInputs() = %v, want %v", discreteInputs[:3], expect)
+	}
+}
+
+func BenchmarkModbusParallelBinaryFileWrite123MultipleRegisters(b *testing.B) {
+	slaver, err := NewBinaryFileSlaveUint8(parallelSlaveNum, b.TempDir())
+	if err != nil {
+		b.Fatalf("NewBinaryFileSlaveUint8() error = %v", err)
+	}
+	defer slaver.Close()
+	benchmarkParallelClients(b, slaver, false, write123MultipleRegisters)
+}
@@ -0,0 +1,448 @@
+package mbserver
+
+import (
+	"encoding/binary"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a bolt slave database. The current values are keyed by slave id,
+// table and address, entries that are zero are not stored. The history is
+// keyed by slave id, table, address, the time of the change and a sequence
+// number, its values are the old and the new value followed by the origin.
+var (
+	boltCurrentBucket = []byte("current")
+	boltHistoryBucket = []byte("history")
+)
+
+// Change is a change of an entry of a slave table, coils and discrete inputs are 0 or 1.
+type Change struct {
+	Time time.Time
+	Old  uint16
+	New  uint16
+	// Origin is who made the change, the address and the role of the master
+	// for a request of the server, empty for the writes of the application,
+	// see SlaveOriginUpdater.
+	Origin string
+}
+
+// HistorySlaver is a Slaver that keeps the changes of its entries.
+type HistorySlaver interface {
+	Slaver
+	// History returns the changes of an entry of slave id from from,
+	// included, to to, excluded, the oldest first. A zero to is unbounded.
+	History(id uint8, table Table, address uint16, from time.Time, to time.Time) ([]Change, error)
+	Close() error
+}
+
+var _ Slaver = new(boltSlaveUint8)
+var _ RangeSlaver = new(boltSlaveUint8)
+var _ SlaveUpdater = new(boltSlaveUint8)
+var _ SlaveOriginUpdater = new(boltSlaveUint8)
+
+type boltSlaveUint8 struct {
+	slaveNum uint8
+	db       *bolt.DB
+	now      func() time.Time
+}
+
+// will create slaveNum slaves, slave id is [1, slaveNum], slaveNumMax is 255, slaveNumMin is 1, the slaves are stored in the bolt database dbPath with the history of their changes
+func NewBoltSlaveUint8(slaveNum uint8, dbPath string) (slaver HistorySlaver, err error) {
+
+	if slaveNum < 1 {
+		slaveNum = 1
+	}
+	var db *bolt.DB
+	if db, err = bolt.Open(dbPath, 0644, &bolt.Options{Timeout: time.Second}); err != nil {
+		err = errors.Wrap(err, "open bolt database fail")
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltCurrentBucket, boltHistoryBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		err = errors.Wrap(err, "create bolt buckets fail")
+		return
+	}
+	slaver = &boltSlaveUint8{slaveNum: slaveNum, db: db, now: time.Now}
+	return
+}
+
+func (s *boltSlaveUint8) IsSlaveIdValid(id uint8) bool { return id > 0 && id <= s.slaveNum }
+
+// view runs fn on the tables of slave id in a read-only transaction.
+func (s *boltSlaveUint8) view(id uint8, fn func(tables boltSlaveTables) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltSlaveTables{tx: tx, realId: s.getRealId(id)})
+	})
+}
+
+// update runs fn on the tables of slave id in a read-write transaction.
+func (s *boltSlaveUint8) update(id uint8, fn func(tables boltSlaveTables) error) error {
+	return s.updateFrom(id, "", fn)
+}
+
+// updateFrom is update, the changes of fn are recorded as made by origin.
+func (s *boltSlaveUint8) updateFrom(id uint8, origin string, fn func(tables boltSlaveTables) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltSlaveTables{tx: tx, realId: s.getRealId(id), time: s.now(), origin: origin})
+	})
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) DiscreteInputs(id uint8) (bs []byte, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.readBits(TableDiscreteInputs, 0, tableLength)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) Coils(id uint8) (bs []byte, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.readBits(TableCoils, 0, tableLength)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) HoldingRegisters(id uint8) (bs []uint16, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.readRegisters(TableHoldingRegisters, 0, tableLength)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) InputRegisters(id uint8) (bs []uint16, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.readRegisters(TableInputRegisters, 0, tableLength)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) SaveDiscreteInputs(id uint8, b []byte) error {
+
+	// the entries past the end of b are zero, as in a table saved whole
+	var table = make([]byte, tableLength)
+	copy(table, b)
+	return s.update(id, func(tables boltSlaveTables) error {
+		return tables.writeBits(TableDiscreteInputs, 0, table)
+	})
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) SaveCoils(id uint8, b []byte) error {
+
+	var table = make([]byte, tableLength)
+	copy(table, b)
+	return s.update(id, func(tables boltSlaveTables) error {
+		return tables.writeBits(TableCoils, 0, table)
+	})
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) SaveHoldingRegisters(id uint8, b []uint16) error {
+
+	var table = make([]uint16, tableLength)
+	copy(table, b)
+	return s.update(id, func(tables boltSlaveTables) error {
+		return tables.writeRegisters(TableHoldingRegisters, 0, table)
+	})
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) SaveInputRegisters(id uint8, b []uint16) error {
+
+	var table = make([]uint16, tableLength)
+	copy(table, b)
+	return s.update(id, func(tables boltSlaveTables) error {
+		return tables.writeRegisters(TableInputRegisters, 0, table)
+	})
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) ReadDiscreteInputs(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.ReadDiscreteInputs(start, count)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) ReadCoils(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.ReadCoils(start, count)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) ReadHoldingRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.ReadHoldingRegisters(start, count)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) ReadInputRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	err = s.view(id, func(tables boltSlaveTables) (err error) {
+		bs, err = tables.ReadInputRegisters(start, count)
+		return
+	})
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) WriteDiscreteInputs(id uint8, start uint16, b []byte) error {
+	return s.update(id, func(tables boltSlaveTables) error { return tables.WriteDiscreteInputs(start, b) })
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) WriteCoils(id uint8, start uint16, b []byte) error {
+	return s.update(id, func(tables boltSlaveTables) error { return tables.WriteCoils(start, b) })
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) WriteHoldingRegisters(id uint8, start uint16, b []uint16) error {
+	return s.update(id, func(tables boltSlaveTables) error { return tables.WriteHoldingRegisters(start, b) })
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) WriteInputRegisters(id uint8, start uint16, b []uint16) error {
+	return s.update(id, func(tables boltSlaveTables) error { return tables.WriteInputRegisters(start, b) })
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+// The writes of fn are one bolt transaction and share the time of their changes.
+func (s *boltSlaveUint8) Update(id uint8, fn func(tables SlaveTables) error) error {
+	return s.UpdateFrom(id, "", fn)
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+// The changes of fn are recorded as made by origin, see Change.
+func (s *boltSlaveUint8) UpdateFrom(id uint8, origin string, fn func(tables SlaveTables) error) error {
+	return s.updateFrom(id, origin, func(tables boltSlaveTables) error { return updateSlaveTables(tables, fn) })
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *boltSlaveUint8) History(id uint8, table Table, address uint16, from time.Time, to time.Time) (changes []Change, err error) {
+
+	var prefix = boltEntryKey(s.getRealId(id), table, address)
+	var fromNano = max(from.UnixNano(), 0)
+	var fromKey = binary.BigEndian.AppendUint64(append([]byte{}, prefix...), uint64(fromNano))
+	// the nanoseconds of a zero time overflow
+	var toNano int64 = math.MaxInt64
+	if !to.IsZero() {
+		toNano = to.UnixNano()
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		var cursor = tx.Bucket(boltHistoryBucket).Cursor()
+		for k, v := cursor.Seek(fromKey); k != nil && len(k) == len(prefix)+16 && string(k[:len(prefix)]) == string(prefix); k, v = cursor.Next() {
+			var changeTime = int64(binary.BigEndian.Uint64(k[len(prefix):]))
+			if changeTime >= toNano {
+				break
+			}
+			changes = append(changes, Change{
+				Time:   time.Unix(0, changeTime),
+				Old:    binary.BigEndian.Uint16(v[0:2]),
+				New:    binary.BigEndian.Uint16(v[2:4]),
+				Origin: string(v[4:]),
+			})
+		}
+		return nil
+	})
+	return
+}
+
+// Close closes the database, the slaves must not be used after.
+func (s *boltSlaveUint8) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltSlaveUint8) getRealId(id uint8) (realId uint8) {
+
+	switch {
+	case id > s.slaveNum:
+		realId = s.slaveNum - 1
+	case id < 1:
+		realId = 0
+	default:
+		realId = id - 1
+	}
+	return
+}
+
+// boltEntryKey is the key of an entry in the current bucket and the prefix of its changes in the history bucket.
+func boltEntryKey(realId uint8, table Table, address uint16) []byte {
+	return []byte{realId, byte(table), byte(address >> 8), byte(address)}
+}
+
+var _ SlaveTables = boltSlaveTables{}
+
+// boltSlaveTables are the tables of a slave in a bolt transaction, time and
+// origin are the time and the origin of the changes of a read-write transaction.
+type boltSlaveTables struct {
+	tx     *bolt.Tx
+	realId uint8
+	time   time.Time
+	origin string
+}
+
+// readEntries reads count entries from start, the entries not stored are zero.
+func (t boltSlaveTables) readEntries(table Table, start int, count int) []uint16 {
+	var values = make([]uint16, count)
+	var cursor = t.tx.Bucket(boltCurrentBucket).Cursor()
+	var prefix = []byte{t.realId, byte(table)}
+	for k, v := cursor.Seek(boltEntryKey(t.realId, table, uint16(start))); k != nil && string(k[:2]) == string(prefix); k, v = cursor.Next() {
+		var address = int(binary.BigEndian.Uint16(k[2:4]))
+		if address >= start+count {
+			break
+		}
+		values[address-start] = binary.BigEndian.Uint16(v)
+	}
+	return values
+}
+
+// writeEntries writes values from start and records the changes in the history.
+func (t boltSlaveTables) writeEntries(table Table, start int, values []uint16) error {
+	var current = t.tx.Bucket(boltCurrentBucket)
+	var history = t.tx.Bucket(boltHistoryBucket)
+	for i, value := range values {
+		var key = boltEntryKey(t.realId, table, uint16(start+i))
+		var old uint16
+		if v := current.Get(key); v != nil {
+			old = binary.BigEndian.Uint16(v)
+		}
+		if old == value {
+			continue
+		}
+
+		var err error
+		if value == 0 {
+			err = current.Delete(key)
+		} else {
+			err = current.Put(key, binary.BigEndian.AppendUint16(nil, value))
+		}
+		if err != nil {
+			return errors.Wrap(err, "write bolt entry fail")
+		}
+		sequence, err := history.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "write bolt history fail")
+		}
+		var historyKey = binary.BigEndian.AppendUint64(key, uint64(t.time.UnixNano()))
+		historyKey = binary.BigEndian.AppendUint64(historyKey, sequence)
+		var change = append([]byte{byte(old >> 8), byte(old), byte(value >> 8), byte(value)}, t.origin...)
+		if err = history.Put(historyKey, change); err != nil {
+			return errors.Wrap(err, "write bolt history fail")
+		}
+	}
+	return nil
+}
+
+func (t boltSlaveTables) readBits(table Table, start int, count int) ([]byte, error) {
+	var values = make([]byte, count)
+	for i, value := range t.readEntries(table, start, count) {
+		values[i] = byte(value)
+	}
+	return values, nil
+}
+
+func (t boltSlaveTables) readRegisters(table Table, start int, count int) ([]uint16, error) {
+	return t.readEntries(table, start, count), nil
+}
+
+func (t boltSlaveTables) writeBits(table Table, start int, values []byte) error {
+	var entries = make([]uint16, len(values))
+	for i, value := range values {
+		entries[i] = uint16(value)
+	}
+	return t.writeEntries(table, start, entries)
+}
+
+func (t boltSlaveTables) writeRegisters(table Table, start int, values []uint16) error {
+	return t.writeEntries(table, start, values)
+}
+
+func (t boltSlaveTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readBits(TableDiscreteInputs, int(start), int(count))
+}
+
+func (t boltSlaveTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readBits(TableCoils, int(start), int(count))
+}
+
+func (t boltSlaveTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readRegisters(TableHoldingRegisters, int(start), int(count))
+}
+
+func (t boltSlaveTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return t.readRegisters(TableInputRegisters, int(start), int(count))
+}
+
+func (t boltSlaveTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeBits(TableDiscreteInputs, int(start), values)
+}
+
+func (t boltSlaveTables) WriteCoils(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeBits(TableCoils, int(start), values)
+}
+
+func (t boltSlaveTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeRegisters(TableHoldingRegisters, int(start), values)
+}
+
+func (t boltSlaveTables) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return t.writeRegisters(TableInputRegisters, int(start), values)
+}
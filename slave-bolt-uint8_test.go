@@ -0,0 +1,158 @@
+package mbserver
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestBoltSlave(t *testing.T, slaveNum uint8) HistorySlaver {
+	slaver, err := NewBoltSlaveUint8(slaveNum, filepath.Join(t.TempDir(), "slave.db"))
+	if err != nil {
+		t.Fatalf("NewBoltSlaveUint8() error = %v", err)
+	}
+	t.Cleanup(func() { slaver.Close() })
+	return slaver
+}
+
+func Test_boltSlaveUint8_History(t *testing.T) {
+	slaver := newTestBoltSlave(t, 2)
+	now := time.Unix(1700000000, 0)
+	slaver.(*boltSlaveUint8).now = func() time.Time { return now }
+
+	rangeSlaver := slaver.(RangeSlaver)
+	for _, value := range []uint16{10, 10, 20, 0} {
+		if err := rangeSlaver.WriteHoldingRegisters(2, 100, []uint16{value}); err != nil {
+			t.Fatalf("WriteHoldingRegisters() error = %v", err)
+		}
+		now = now.Add(time.Minute)
+	}
+	rangeSlaver.WriteHoldingRegisters(1, 100, []uint16{5})
+	rangeSlaver.WriteCoils(2, 100, []byte{1})
+
+	// The unchanged write of 10 is not a change.
+	changes, err := slaver.History(2, TableHoldingRegisters, 100, time.Unix(1700000000, 0), now)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	expect := []Change{
+		{time.Unix(1700000000, 0), 0, 10, ""},
+		{time.Unix(1700000120, 0), 10, 20, ""},
+		{time.Unix(1700000180, 0), 20, 0, ""},
+	}
+	if len(changes) != len(expect) {
+		t.Fatalf("History() = %v, want %v", changes, expect)
+	}
+	for i := range expect {
+		if !changes[i].Time.Equal(expect[i].Time) || changes[i].Old != expect[i].Old || changes[i].New != expect[i].New || changes[i].Origin != expect[i].Origin {
+			t.Errorf("History()[%d] = %v, want %v", i, changes[i], expect[i])
+		}
+	}
+
+	// The range includes from and excludes to.
+	changes, _ = slaver.History(2, TableHoldingRegisters, 100, time.Unix(1700000120, 0), time.Unix(1700000180, 0))
+	if len(changes) != 1 || changes[0].New != 20 {
+		t.Errorf("History() = %v, want the change to 20", changes)
+	}
+	changes, _ = slaver.History(2, TableHoldingRegisters, 100, time.Unix(1700000120, 0), time.Time{})
+	if len(changes) != 2 || changes[0].New != 20 || changes[1].New != 0 {
+		t.Errorf("History() = %v, want the changes to 20 and 0", changes)
+	}
+	changes, _ = slaver.History(2, TableHoldingRegisters, 101, time.Time{}, now)
+	if len(changes) != 0 {
+		t.Errorf("History() = %v, want none", changes)
+	}
+}
+
+func Test_boltSlaveUint8_HistoryOrigin(t *testing.T) {
+	slaver := newTestBoltSlave(t, 1)
+	s := NewServer(slaver)
+	defer s.Close()
+	addr := getFreePort()
+	if err := s.ListenTCP(addr); err != nil {
+		t.Fatalf("failed to listen, got %v\n", err)
+	}
+
+	// Two masters write register 1 in turn, then the application does.
+	var origins []string
+	for _, value := range []byte{1, 2} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to connect, got %v\n", err)
+		}
+		defer conn.Close()
+		request := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x06, 0x00, 0x01, 0x00, value}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err = conn.Write(request); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		if _, err = io.ReadFull(conn, make([]byte, len(request))); err != nil {
+			t.Fatalf("expected nil, got %v\n", err)
+		}
+		origins = append(origins, conn.LocalAddr().String())
+	}
+	if err := slaver.(RangeSlaver).WriteHoldingRegisters(1, 1, []uint16{3}); err != nil {
+		t.Fatalf("WriteHoldingRegisters() error = %v", err)
+	}
+
+	// A handler of any function writing through the server records its origin.
+	s.RegisterFunctionHandler(3, func(s *Server, frame Framer) ([]byte, *Exception) {
+		err := s.update(frame, func(tables SlaveTables) error { return tables.WriteHoldingRegisters(1, []uint16{4}) })
+		if err != nil {
+			return []byte{}, &SlaveDeviceFailure
+		}
+		return []byte{0}, &Success
+	})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect, got %v\n", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write([]byte{0x00, 0x02, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x01, 0x00, 0x01}); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	if _, err = io.ReadFull(conn, make([]byte, 9)); err != nil {
+		t.Fatalf("expected nil, got %v\n", err)
+	}
+	origins = append(origins, conn.LocalAddr().String())
+
+	changes, err := slaver.History(1, TableHoldingRegisters, 1, time.Time{}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	expect := []string{origins[0], origins[1], "", origins[2]}
+	if len(changes) != len(expect) {
+		t.Fatalf("History() = %v, want the origins %v", changes, expect)
+	}
+	for i := range expect {
+		if changes[i].Origin != expect[i] {
+			t.Errorf("History()[%d].Origin = %q, want %q", i, changes[i].Origin, expect[i])
+		}
+	}
+}
+
+func Test_boltSlaveUint8_Reopen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "slave.db")
+	slaver, err := NewBoltSlaveUint8(1, dbPath)
+	if err != nil {
+		t.Fatalf("NewBoltSlaveUint8() error = %v", err)
+	}
+	slaver.SaveInputRegisters(1, []uint16{0, 7})
+	slaver.Close()
+
+	if slaver, err = NewBoltSlaveUint8(1, dbPath); err != nil {
+		t.Fatalf("NewBoltSlaveUint8() error = %v", err)
+	}
+	defer slaver.Close()
+	inputRegisters, _ := slaver.InputRegisters(1)
+	if len(inputRegisters) != 65536 || inputRegisters[1] != 7 {
+		t.Errorf("InputRegisters() = %v registers, want 7 at 1", len(inputRegisters))
+	}
+	changes, _ := slaver.History(1, TableInputRegisters, 1, time.Time{}, time.Now().Add(time.Hour))
+	if len(changes) != 1 {
+		t.Errorf("History() = %v, want one change", changes)
+	}
+}
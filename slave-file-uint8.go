@@ -1,15 +1,15 @@
 package mbserver
 
 import (
-	"encoding/hex"
 	"fmt"
-	"os"
+	"log"
 	"sync"
-
-	"github.com/pkg/errors"
 )
 
 var _ Slaver = new(fileSlaveUint8)
+var _ SlaveUpdater = new(fileSlaveUint8)
+var _ HoldingRegistersUpdater = new(fileSlaveUint8)
+var _ RangeSlaver = new(fileSlaveUint8)
 
 type fileSlaveUint8 struct {
 	slaveNum     uint8
@@ -28,14 +28,30 @@ func NewFileSlaveUint8(slaveNum uint8, fileStoreDir string) (slaver Slaver) {
 	if fileStoreDir == "" {
 		fileStoreDir = "./file-slave"
 	}
-	slaver = &fileSlaveUint8{
+	var s = &fileSlaveUint8{
 		slaveNum:     slaveNum,
 		slaveLock:    make([]sync.RWMutex, slaveNum),
 		fileStoreDir: fileStoreDir,
 	}
+	s.recover()
+	slaver = s
 	return
 }
 
+// recover repairs the files of the slaves once, before they are used, see
+// localStorageRecover; the reads of a file that could not be repaired fall
+// back to its last good copy.
+func (s *fileSlaveUint8) recover() {
+	for realId := 0; realId < int(s.slaveNum); realId++ {
+		for _, table := range []Table{TableDiscreteInputs, TableCoils, TableHoldingRegisters, TableInputRegisters} {
+			filePath := fileSlaveTables{s, uint8(realId)}.filePath(table)
+			if err := localStorageRecover(s.fileStoreDir, filePath); err != nil {
+				log.Printf("recover local storage file %s fail, err: %s\n", filePath, err.Error())
+			}
+		}
+	}
+}
+
 func (s *fileSlaveUint8) IsSlaveIdValid(id uint8) bool { return id > 0 && id <= s.slaveNum }
 
 // if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
@@ -43,7 +59,7 @@ func (s *fileSlaveUint8) DiscreteInputs(id uint8) (bs []byte, err error) {
 
 	id = s.getRealId(id)
 	s.slaveLock[id].RLock()
-	bs, err = s.localStorageFileRead(fmt.Sprintf("%s/%d-discreteInputs", s.fileStoreDir, id+1))
+	bs, err = localStorageFileRead(fmt.Sprintf("%s/%d-discreteInputs", s.fileStoreDir, id+1))
 	s.slaveLock[id].RUnlock()
 	if err == nil && len(bs) < 65536 {
 		var newBs = make([]byte, 65536)
@@ -58,7 +74,7 @@ func (s *fileSlaveUint8) Coils(id uint8) (bs []byte, err error) {
 
 	id = s.getRealId(id)
 	s.slaveLock[id].RLock()
-	bs, err = s.localStorageFileRead(fmt.Sprintf("%s/%d-coils", s.fileStoreDir, id+1))
+	bs, err = localStorageFileRead(fmt.Sprintf("%s/%d-coils", s.fileStoreDir, id+1))
 	s.slaveLock[id].RUnlock()
 	if err == nil && len(bs) < 65536 {
 		var newBs = make([]byte, 65536)
@@ -74,7 +90,7 @@ func (s *fileSlaveUint8) HoldingRegisters(id uint8) (bs []uint16, err error) {
 	id = s.getRealId(id)
 	var bsFileContent []byte
 	s.slaveLock[id].RLock()
-	bsFileContent, err = s.localStorageFileRead(fmt.Sprintf("%s/%d-holdingRegisters", s.fileStoreDir, id+1))
+	bsFileContent, err = localStorageFileRead(fmt.Sprintf("%s/%d-holdingRegisters", s.fileStoreDir, id+1))
 	s.slaveLock[id].RUnlock()
 	if err == nil {
 		if bs = BytesToUint16(bsFileContent); len(bs) < 65536 {
@@ -92,7 +108,7 @@ func (s *fileSlaveUint8) InputRegisters(id uint8) (bs []uint16, err error) {
 	id = s.getRealId(id)
 	var bsFileContent []byte
 	s.slaveLock[id].RLock()
-	bsFileContent, err = s.localStorageFileRead(fmt.Sprintf("%s/%d-inputRegisters", s.fileStoreDir, id+1))
+	bsFileContent, err = localStorageFileRead(fmt.Sprintf("%s/%d-inputRegisters", s.fileStoreDir, id+1))
 	s.slaveLock[id].RUnlock()
 	if err == nil {
 		if bs = BytesToUint16(bsFileContent); len(bs) < 65536 {
@@ -109,7 +125,7 @@ func (s *fileSlaveUint8) SaveDiscreteInputs(id uint8, b []byte) (err error) {
 
 	id = s.getRealId(id)
 	s.slaveLock[id].Lock()
-	_, err = s.localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-discreteInputs", s.fileStoreDir, id+1), b)
+	_, err = localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-discreteInputs", s.fileStoreDir, id+1), b)
 	s.slaveLock[id].Unlock()
 	return
 }
@@ -119,7 +135,7 @@ func (s *fileSlaveUint8) SaveCoils(id uint8, b []byte) (err error) {
 
 	id = s.getRealId(id)
 	s.slaveLock[id].Lock()
-	_, err = s.localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-coils", s.fileStoreDir, id+1), b)
+	_, err = localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-coils", s.fileStoreDir, id+1), b)
 	s.slaveLock[id].Unlock()
 	return
 }
@@ -129,7 +145,7 @@ func (s *fileSlaveUint8) SaveHoldingRegisters(id uint8, b []uint16) (err error)
 
 	id = s.getRealId(id)
 	s.slaveLock[id].Lock()
-	_, err = s.localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-holdingRegisters", s.fileStoreDir, id+1), Uint16ToBytes(b))
+	_, err = localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-holdingRegisters", s.fileStoreDir, id+1), Uint16ToBytes(b))
 	s.slaveLock[id].Unlock()
 	return
 }
@@ -139,11 +155,105 @@ func (s *fileSlaveUint8) SaveInputRegisters(id uint8, b []uint16) (err error) {
 
 	id = s.getRealId(id)
 	s.slaveLock[id].Lock()
-	_, err = s.localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-inputRegisters", s.fileStoreDir, id+1), Uint16ToBytes(b))
+	_, err = localStorageWrite(s.fileStoreDir, fmt.Sprintf("%s/%d-inputRegisters", s.fileStoreDir, id+1), Uint16ToBytes(b))
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) ReadDiscreteInputs(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = fileSlaveTables{s, id}.ReadDiscreteInputs(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) ReadCoils(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = fileSlaveTables{s, id}.ReadCoils(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) ReadHoldingRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = fileSlaveTables{s, id}.ReadHoldingRegisters(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) ReadInputRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = fileSlaveTables{s, id}.ReadInputRegisters(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) WriteDiscreteInputs(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = fileSlaveTables{s, id}.WriteDiscreteInputs(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) WriteCoils(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = fileSlaveTables{s, id}.WriteCoils(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) WriteHoldingRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = fileSlaveTables{s, id}.WriteHoldingRegisters(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) WriteInputRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = fileSlaveTables{s, id}.WriteInputRegisters(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *fileSlaveUint8) Update(id uint8, fn func(tables SlaveTables) error) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = updateSlaveTables(fileSlaveTables{s, id}, fn)
 	s.slaveLock[id].Unlock()
 	return
 }
 
+func (s *fileSlaveUint8) UpdateHoldingRegisters(id uint8, fn func(holdingRegisters []uint16) error) error {
+	return updateHoldingRegisters(s, id, fn)
+}
+
 func (s *fileSlaveUint8) getRealId(id uint8) (realId uint8) {
 
 	switch {
@@ -157,46 +267,78 @@ func (s *fileSlaveUint8) getRealId(id uint8) (realId uint8) {
 	return
 }
 
-func (s *fileSlaveUint8) localStorageFileRead(filePath string) (bsFileContent []byte, err error) {
+var _ SlaveTables = fileSlaveTables{}
 
-	if bsFileContent, err = os.ReadFile(filePath); err == nil {
-		if lenBsFileContent := len(bsFileContent); lenBsFileContent > 0 {
-			var newBs = make([]byte, hex.DecodedLen(lenBsFileContent))
-			if _, err = hex.Decode(newBs, bsFileContent); err == nil {
-				bsFileContent = newBs
-				return
-			}
-			err = errors.Wrap(err, "hex decode file content fail")
-		}
-		return
+// fileSlaveTables are the tables of a slave of fileSlaveUint8, the caller holds the slave's lock.
+type fileSlaveTables struct {
+	s      *fileSlaveUint8
+	realId uint8
+}
+
+func (t fileSlaveTables) filePath(table Table) string {
+	return fmt.Sprintf("%s/%d-%s", t.s.fileStoreDir, t.realId+1, table)
+}
+
+func (t fileSlaveTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
 	}
-	if os.IsNotExist(err) {
-		bsFileContent, err = nil, nil
-		return
+	return localStorageReadAt(t.filePath(TableDiscreteInputs), int64(start), int(count))
+}
+
+func (t fileSlaveTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
 	}
-	err = errors.Wrap(err, "read file fail")
-	return
+	return localStorageReadAt(t.filePath(TableCoils), int64(start), int(count))
+}
+
+func (t fileSlaveTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	bs, err := localStorageReadAt(t.filePath(TableHoldingRegisters), int64(start)*2, int(count)*2)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToUint16(bs), nil
 }
 
-func (s *fileSlaveUint8) localStorageWrite(fileDir, filePath string, bsFileContent []byte) (n int, err error) {
+func (t fileSlaveTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	bs, err := localStorageReadAt(t.filePath(TableInputRegisters), int64(start)*2, int(count)*2)
+	if err != nil {
+		return nil, err
+	}
+	return BytesToUint16(bs), nil
+}
 
-	// mkdir all dir
-	if err = os.MkdirAll(fileDir, 0755); err != nil {
-		err = errors.Wrap(err, "mkdir all fail")
-		return
+func (t fileSlaveTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
 	}
-	// open/create file
-	var file *os.File
-	if file, err = os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
-		err = errors.Wrap(err, "open file fail")
-		return
+	return localStorageWriteAt(t.s.fileStoreDir, t.filePath(TableDiscreteInputs), int64(start), values)
+}
+
+func (t fileSlaveTables) WriteCoils(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
 	}
-	defer file.Close()
-	// write
-	var encodeBs = make([]byte, hex.EncodedLen(len(bsFileContent)))
-	hex.Encode(encodeBs, bsFileContent)
-	if n, err = file.Write(encodeBs); err != nil {
-		err = errors.Wrap(err, "write file content fail")
+	return localStorageWriteAt(t.s.fileStoreDir, t.filePath(TableCoils), int64(start), values)
+}
+
+func (t fileSlaveTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
 	}
-	return
+	return localStorageWriteAt(t.s.fileStoreDir, t.filePath(TableHoldingRegisters), int64(start)*2, Uint16ToBytes(values))
+}
+
+func (t fileSlaveTables) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	return localStorageWriteAt(t.s.fileStoreDir, t.filePath(TableInputRegisters), int64(start)*2, Uint16ToBytes(values))
 }
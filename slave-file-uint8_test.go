@@ -1,56 +1,12 @@
 package mbserver
 
 import (
+	"os"
 	"reflect"
 	"sync"
 	"testing"
 )
 
-func Test_fileSlaveUint8_localStorageFileRead(t *testing.T) {
-	type fields struct {
-		slaveNum     uint8
-		slaveLock    []sync.RWMutex
-		fileStoreDir string
-	}
-	type args struct {
-		filePath string
-	}
-	tests := []struct {
-		name              string
-		fields            fields
-		args              args
-		wantBsFileContent []byte
-		wantErr           bool
-	}{
-		{
-			name:   "test no exist file",
-			fields: fields{},
-			args: args{
-				filePath: "./no-exist-file",
-			},
-			wantBsFileContent: nil,
-			wantErr:           false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			s := &fileSlaveUint8{
-				slaveNum:     tt.fields.slaveNum,
-				slaveLock:    tt.fields.slaveLock,
-				fileStoreDir: tt.fields.fileStoreDir,
-			}
-			gotBsFileContent, err := s.localStorageFileRead(tt.args.filePath)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("fileSlaveUint8.localStorageFileRead() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(gotBsFileContent, tt.wantBsFileContent) {
-				t.Errorf("fileSlaveUint8.localStorageFileRead() = %v, want %v", gotBsFileContent, tt.wantBsFileContent)
-			}
-		})
-	}
-}
-
 func Test_fileSlaveUint8_SaveDiscreteInputs(t *testing.T) {
 	type fields struct {
 		slaveNum     uint8
@@ -238,3 +194,85 @@ func Test_fileSlaveUint8_HoldingRegisters(t *testing.T) {
 		})
 	}
 }
+
+func Test_fileSlaveUint8_Update(t *testing.T) {
+	type args struct {
+		id    uint8
+		value uint16
+		fnErr error
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantBs  []uint16
+		wantErr bool
+	}{
+		{
+			name: "test01",
+			args: args{
+				id:    2,
+				value: 2025,
+			},
+			wantBs:  []uint16{0, 2025, 0},
+			wantErr: false,
+		},
+		{
+			name: "fn error discards the update",
+			args: args{
+				id:    2,
+				value: 2025,
+				fnErr: IllegalDataValue,
+			},
+			wantBs:  []uint16{0, 0, 0},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFileSlaveUint8(2, t.TempDir()).(*fileSlaveUint8)
+			err := s.Update(tt.args.id, func(tables SlaveTables) error {
+				if err := tables.WriteHoldingRegisters(1, []uint16{tt.args.value}); err != nil {
+					return err
+				}
+				return tt.args.fnErr
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("fileSlaveUint8.Update() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			gotBs, err := s.HoldingRegisters(tt.args.id)
+			if err != nil {
+				t.Errorf("fileSlaveUint8.HoldingRegisters() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(gotBs[:3], tt.wantBs) {
+				t.Errorf("fileSlaveUint8.HoldingRegisters() = %v, want %v", gotBs[:3], tt.wantBs)
+			}
+		})
+	}
+}
+
+func Test_fileSlaveUint8_recover(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileSlaveUint8(1, dir)
+	if err := s.(RangeSlaver).WriteHoldingRegisters(1, 0, []uint16{1, 2}); err != nil {
+		t.Fatalf("WriteHoldingRegisters() error = %v", err)
+	}
+	// The power failed while register 1 was written in place.
+	filePath := dir + "/1-holdingRegisters"
+	if err := localStorageWriteJournal(dir, filePath, 4, []byte("0007")); err != nil {
+		t.Fatalf("localStorageWriteJournal() error = %v", err)
+	}
+	file, _ := os.OpenFile(filePath, os.O_WRONLY, 0644)
+	file.WriteAt([]byte("00"), 4)
+	file.Close()
+
+	// The slave is repaired when it is opened again.
+	values, err := NewRangeSlaver(NewFileSlaveUint8(1, dir)).ReadHoldingRegisters(1, 0, 2)
+	if err != nil {
+		t.Fatalf("ReadHoldingRegisters() error = %v", err)
+	}
+	if !reflect.DeepEqual(values, []uint16{1, 7}) {
+		t.Errorf("ReadHoldingRegisters() = %v, want %v", values, []uint16{1, 7})
+	}
+}
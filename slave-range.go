@@ -0,0 +1,142 @@
+package mbserver
+
+import (
+	"github.com/pkg/errors"
+)
+
+// tableLength is the number of entries of each table of a slave.
+const tableLength = 65536
+
+// checkTableRange checks that count entries from start fit in a table.
+func checkTableRange(start uint16, count int) error {
+	if int(start)+count > tableLength {
+		return errors.Errorf("range of %d entries from %d is past the end of the table", count, start)
+	}
+	return nil
+}
+
+// readTableRange copies count entries from start, entries past the end of a short table are zero.
+func readTableRange[T byte | uint16](table []T, start uint16, count uint16) []T {
+	values := make([]T, count)
+	if int(start) < len(table) {
+		copy(values, table[start:])
+	}
+	return values
+}
+
+// writeTableRange copies values to the table from start, growing a short
+// table, and returns the table.
+func writeTableRange[T byte | uint16](table []T, start uint16, values []T) []T {
+	if end := int(start) + len(values); len(table) < end {
+		newTable := make([]T, tableLength)
+		copy(newTable, table)
+		table = newTable
+	}
+	copy(table[start:], values)
+	return table
+}
+
+var _ RangeSlaver = wholeTableSlaver{}
+
+// wholeTableSlaver reads and writes ranges through the whole tables of a Slaver.
+type wholeTableSlaver struct {
+	Slaver
+}
+
+// NewRangeSlaver returns slaver if it is a RangeSlaver, otherwise a RangeSlaver
+// that gets and saves the whole tables of slaver. A write gets the table,
+// changes the range and saves it, so it must not run alongside other writes of the slave.
+func NewRangeSlaver(slaver Slaver) RangeSlaver {
+	if rangeSlaver, ok := slaver.(RangeSlaver); ok {
+		return rangeSlaver
+	}
+	return wholeTableSlaver{slaver}
+}
+
+func (s wholeTableSlaver) ReadDiscreteInputs(id uint8, start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	table, err := s.DiscreteInputs(id)
+	if err != nil {
+		return nil, err
+	}
+	return readTableRange(table, start, count), nil
+}
+
+func (s wholeTableSlaver) ReadCoils(id uint8, start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	table, err := s.Coils(id)
+	if err != nil {
+		return nil, err
+	}
+	return readTableRange(table, start, count), nil
+}
+
+func (s wholeTableSlaver) ReadHoldingRegisters(id uint8, start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	table, err := s.HoldingRegisters(id)
+	if err != nil {
+		return nil, err
+	}
+	return readTableRange(table, start, count), nil
+}
+
+func (s wholeTableSlaver) ReadInputRegisters(id uint8, start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	table, err := s.InputRegisters(id)
+	if err != nil {
+		return nil, err
+	}
+	return readTableRange(table, start, count), nil
+}
+
+func (s wholeTableSlaver) WriteDiscreteInputs(id uint8, start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	table, err := s.DiscreteInputs(id)
+	if err != nil {
+		return err
+	}
+	return s.SaveDiscreteInputs(id, writeTableRange(table, start, values))
+}
+
+func (s wholeTableSlaver) WriteCoils(id uint8, start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	table, err := s.Coils(id)
+	if err != nil {
+		return err
+	}
+	return s.SaveCoils(id, writeTableRange(table, start, values))
+}
+
+func (s wholeTableSlaver) WriteHoldingRegisters(id uint8, start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	table, err := s.HoldingRegisters(id)
+	if err != nil {
+		return err
+	}
+	return s.SaveHoldingRegisters(id, writeTableRange(table, start, values))
+}
+
+func (s wholeTableSlaver) WriteInputRegisters(id uint8, start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	table, err := s.InputRegisters(id)
+	if err != nil {
+		return err
+	}
+	return s.SaveInputRegisters(id, writeTableRange(table, start, values))
+}
@@ -0,0 +1,82 @@
+package mbserver
+
+import (
+	"testing"
+)
+
+func TestRangeSlaver(t *testing.T) {
+	slavers := map[string]func(t *testing.T) Slaver{
+		"memory":  func(t *testing.T) Slaver { return NewMemorySlaveUint8(2) },
+		"file":    func(t *testing.T) Slaver { return NewFileSlaveUint8(2, t.TempDir()) },
+		"binary":  func(t *testing.T) Slaver { return newTestBinaryFileSlave(t, 2, t.TempDir()) },
+		"bolt":    func(t *testing.T) Slaver { return newTestBoltSlave(t, 2) },
+		"sparse":  func(t *testing.T) Slaver { return newTestSparseSlave(t, 2) },
+		"adapter": func(t *testing.T) Slaver { return struct{ Slaver }{NewMemorySlaveUint8(2)} },
+	}
+	for name, newSlaver := range slavers {
+		t.Run(name, func(t *testing.T) {
+			slaver := newSlaver(t)
+			rangeSlaver := NewRangeSlaver(slaver)
+
+			// A table not written yet reads as zeros.
+			holdingRegisters, err := rangeSlaver.ReadHoldingRegisters(2, 65534, 2)
+			if err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if !isEqual([]uint16{0, 0}, holdingRegisters) {
+				t.Errorf("expected %v, got %v", []uint16{0, 0}, holdingRegisters)
+			}
+
+			if err = rangeSlaver.WriteHoldingRegisters(2, 100, []uint16{0x1234, 0xABCD}); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if err = rangeSlaver.WriteInputRegisters(2, 65535, []uint16{7}); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if err = rangeSlaver.WriteCoils(2, 8, []byte{1, 0, 1}); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+			if err = rangeSlaver.WriteDiscreteInputs(2, 0, []byte{1}); err != nil {
+				t.Fatalf("expected nil, got %v", err)
+			}
+
+			holdingRegisters, _ = rangeSlaver.ReadHoldingRegisters(2, 99, 4)
+			if expect := []uint16{0, 0x1234, 0xABCD, 0}; !isEqual(expect, holdingRegisters) {
+				t.Errorf("expected %v, got %v", expect, holdingRegisters)
+			}
+			inputRegisters, _ := rangeSlaver.ReadInputRegisters(2, 65535, 1)
+			if expect := []uint16{7}; !isEqual(expect, inputRegisters) {
+				t.Errorf("expected %v, got %v", expect, inputRegisters)
+			}
+			coils, _ := rangeSlaver.ReadCoils(2, 7, 5)
+			if expect := []byte{0, 1, 0, 1, 0}; !isEqual(expect, coils) {
+				t.Errorf("expected %v, got %v", expect, coils)
+			}
+			discreteInputs, _ := rangeSlaver.ReadDiscreteInputs(2, 0, 2)
+			if expect := []byte{1, 0}; !isEqual(expect, discreteInputs) {
+				t.Errorf("expected %v, got %v", expect, discreteInputs)
+			}
+
+			// The whole tables see the ranges, other slaves do not.
+			table, _ := slaver.HoldingRegisters(2)
+			if len(table) != 65536 || table[100] != 0x1234 || table[101] != 0xABCD {
+				t.Errorf("expected 65536 registers with 0x1234 and 0xABCD at 100, got %v registers", len(table))
+			}
+			table, _ = slaver.InputRegisters(2)
+			if len(table) != 65536 || table[65535] != 7 {
+				t.Errorf("expected 65536 registers with 7 at 65535, got %v registers", len(table))
+			}
+			holdingRegisters, _ = rangeSlaver.ReadHoldingRegisters(1, 100, 1)
+			if expect := []uint16{0}; !isEqual(expect, holdingRegisters) {
+				t.Errorf("expected %v, got %v", expect, holdingRegisters)
+			}
+
+			if _, err = rangeSlaver.ReadHoldingRegisters(2, 65535, 2); err == nil {
+				t.Errorf("expected error not nil, got %v", err)
+			}
+			if err = rangeSlaver.WriteCoils(2, 65535, []byte{1, 1}); err == nil {
+				t.Errorf("expected error not nil, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,347 @@
+package mbserver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// AddressRange is the addresses First to Last, included, of a table.
+type AddressRange struct {
+	First uint16
+	Last  uint16
+}
+
+// SlaveMap declares the defined addresses of the tables of a slave.
+type SlaveMap struct {
+	DiscreteInputs   []AddressRange
+	Coils            []AddressRange
+	HoldingRegisters []AddressRange
+	InputRegisters   []AddressRange
+}
+
+// sparseBlock holds the entries of consecutive defined addresses from first.
+type sparseBlock[T byte | uint16] struct {
+	first  int
+	values []T
+}
+
+// sparseTable holds the entries of the defined addresses of a table, its
+// blocks are sorted and apart.
+type sparseTable[T byte | uint16] struct {
+	blocks []sparseBlock[T]
+}
+
+// newSparseTable allocates the entries of ranges, merging the ranges that overlap or touch.
+func newSparseTable[T byte | uint16](ranges []AddressRange) (table sparseTable[T], err error) {
+
+	var sorted = make([]AddressRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].First < sorted[j].First })
+	var merged []AddressRange
+	for _, addressRange := range sorted {
+		if addressRange.First > addressRange.Last {
+			err = errors.Errorf("address range %d-%d ends before it starts", addressRange.First, addressRange.Last)
+			return
+		}
+		if last := len(merged) - 1; last >= 0 && int(addressRange.First) <= int(merged[last].Last)+1 {
+			merged[last].Last = max(merged[last].Last, addressRange.Last)
+			continue
+		}
+		merged = append(merged, addressRange)
+	}
+	for _, addressRange := range merged {
+		table.blocks = append(table.blocks, sparseBlock[T]{
+			first:  int(addressRange.First),
+			values: make([]T, int(addressRange.Last)-int(addressRange.First)+1),
+		})
+	}
+	return
+}
+
+// entries returns the entries of count addresses from start, IllegalDataAddress if one is not defined.
+func (t *sparseTable[T]) entries(start uint16, count int) ([]T, error) {
+	// the last block starting at or before start
+	var i = sort.Search(len(t.blocks), func(i int) bool { return t.blocks[i].first > int(start) }) - 1
+	if i < 0 || int(start)+count > t.blocks[i].first+len(t.blocks[i].values) {
+		return nil, IllegalDataAddress
+	}
+	var offset = int(start) - t.blocks[i].first
+	return t.blocks[i].values[offset : offset+count], nil
+}
+
+func (t *sparseTable[T]) read(start uint16, count int) ([]T, error) {
+	var entries, err = t.entries(start, count)
+	if err != nil {
+		return nil, err
+	}
+	var values = make([]T, count)
+	copy(values, entries)
+	return values, nil
+}
+
+func (t *sparseTable[T]) write(start uint16, values []T) error {
+	var entries, err = t.entries(start, len(values))
+	if err != nil {
+		return err
+	}
+	copy(entries, values)
+	return nil
+}
+
+// whole returns the whole table, the undefined addresses are zero.
+func (t *sparseTable[T]) whole() []T {
+	var values = make([]T, tableLength)
+	for _, block := range t.blocks {
+		copy(values[block.first:], block.values)
+	}
+	return values
+}
+
+// saveWhole saves the defined addresses of a whole table, the entries past the end of values are zero.
+func (t *sparseTable[T]) saveWhole(values []T) {
+	for _, block := range t.blocks {
+		clear(block.values)
+		if block.first < len(values) {
+			copy(block.values, values[block.first:])
+		}
+	}
+}
+
+// sparseSlave holds the defined addresses of a slave.
+type sparseSlave struct {
+	lock             sync.RWMutex
+	discreteInputs   sparseTable[byte]
+	coils            sparseTable[byte]
+	holdingRegisters sparseTable[uint16]
+	inputRegisters   sparseTable[uint16]
+}
+
+var _ Slaver = new(sparseSlaveUint8)
+var _ RangeSlaver = new(sparseSlaveUint8)
+var _ SlaveUpdater = new(sparseSlaveUint8)
+
+type sparseSlaveUint8 struct {
+	slaves [256]*sparseSlave
+}
+
+// will create the slaves of maps, only the declared addresses of a slave are
+// allocated and accessible, the others are IllegalDataAddress; slave id is a
+// key of maps, 0 is the broadcast address and is not a slave
+func NewSparseSlaveUint8(maps map[uint8]SlaveMap) (slaver Slaver, err error) {
+
+	var s = new(sparseSlaveUint8)
+	for id, slaveMap := range maps {
+		if id == 0 {
+			err = errors.New("slave id 0 is the broadcast address")
+			return
+		}
+		var slave = new(sparseSlave)
+		if slave.discreteInputs, err = newSparseTable[byte](slaveMap.DiscreteInputs); err == nil {
+			if slave.coils, err = newSparseTable[byte](slaveMap.Coils); err == nil {
+				if slave.holdingRegisters, err = newSparseTable[uint16](slaveMap.HoldingRegisters); err == nil {
+					slave.inputRegisters, err = newSparseTable[uint16](slaveMap.InputRegisters)
+				}
+			}
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "slave %d", id)
+			return
+		}
+		s.slaves[id] = slave
+	}
+	slaver = s
+	return
+}
+
+func (s *sparseSlaveUint8) IsSlaveIdValid(id uint8) bool { return s.slaves[id] != nil }
+
+// slave returns slave id, an error if it is not declared.
+func (s *sparseSlaveUint8) slave(id uint8) (*sparseSlave, error) {
+	if s.slaves[id] == nil {
+		return nil, errors.Errorf("slave %d is not declared", id)
+	}
+	return s.slaves[id], nil
+}
+
+// view runs fn on the tables of slave id under its read lock.
+func (s *sparseSlaveUint8) view(id uint8, fn func(tables sparseSlaveTables) error) error {
+	var slave, err = s.slave(id)
+	if err != nil {
+		return err
+	}
+	slave.lock.RLock()
+	defer slave.lock.RUnlock()
+	return fn(sparseSlaveTables{slave})
+}
+
+// update runs fn on the tables of slave id under its write lock.
+func (s *sparseSlaveUint8) update(id uint8, fn func(tables sparseSlaveTables) error) error {
+	var slave, err = s.slave(id)
+	if err != nil {
+		return err
+	}
+	slave.lock.Lock()
+	defer slave.lock.Unlock()
+	return fn(sparseSlaveTables{slave})
+}
+
+// DiscreteInputs returns the whole table, the undefined addresses are zero.
+func (s *sparseSlaveUint8) DiscreteInputs(id uint8) (bs []byte, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) error {
+		bs = tables.discreteInputs.whole()
+		return nil
+	})
+	return
+}
+
+// Coils returns the whole table, the undefined addresses are zero.
+func (s *sparseSlaveUint8) Coils(id uint8) (bs []byte, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) error {
+		bs = tables.coils.whole()
+		return nil
+	})
+	return
+}
+
+// HoldingRegisters returns the whole table, the undefined addresses are zero.
+func (s *sparseSlaveUint8) HoldingRegisters(id uint8) (bs []uint16, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) error {
+		bs = tables.holdingRegisters.whole()
+		return nil
+	})
+	return
+}
+
+// InputRegisters returns the whole table, the undefined addresses are zero.
+func (s *sparseSlaveUint8) InputRegisters(id uint8) (bs []uint16, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) error {
+		bs = tables.inputRegisters.whole()
+		return nil
+	})
+	return
+}
+
+// SaveDiscreteInputs saves the defined addresses of the whole table, the others are ignored.
+func (s *sparseSlaveUint8) SaveDiscreteInputs(id uint8, b []byte) error {
+	return s.update(id, func(tables sparseSlaveTables) error {
+		tables.discreteInputs.saveWhole(b)
+		return nil
+	})
+}
+
+// SaveCoils saves the defined addresses of the whole table, the others are ignored.
+func (s *sparseSlaveUint8) SaveCoils(id uint8, b []byte) error {
+	return s.update(id, func(tables sparseSlaveTables) error {
+		tables.coils.saveWhole(b)
+		return nil
+	})
+}
+
+// SaveHoldingRegisters saves the defined addresses of the whole table, the others are ignored.
+func (s *sparseSlaveUint8) SaveHoldingRegisters(id uint8, b []uint16) error {
+	return s.update(id, func(tables sparseSlaveTables) error {
+		tables.holdingRegisters.saveWhole(b)
+		return nil
+	})
+}
+
+// SaveInputRegisters saves the defined addresses of the whole table, the others are ignored.
+func (s *sparseSlaveUint8) SaveInputRegisters(id uint8, b []uint16) error {
+	return s.update(id, func(tables sparseSlaveTables) error {
+		tables.inputRegisters.saveWhole(b)
+		return nil
+	})
+}
+
+func (s *sparseSlaveUint8) ReadDiscreteInputs(id uint8, start uint16, count uint16) (bs []byte, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) (err error) {
+		bs, err = tables.ReadDiscreteInputs(start, count)
+		return
+	})
+	return
+}
+
+func (s *sparseSlaveUint8) ReadCoils(id uint8, start uint16, count uint16) (bs []byte, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) (err error) {
+		bs, err = tables.ReadCoils(start, count)
+		return
+	})
+	return
+}
+
+func (s *sparseSlaveUint8) ReadHoldingRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) (err error) {
+		bs, err = tables.ReadHoldingRegisters(start, count)
+		return
+	})
+	return
+}
+
+func (s *sparseSlaveUint8) ReadInputRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+	err = s.view(id, func(tables sparseSlaveTables) (err error) {
+		bs, err = tables.ReadInputRegisters(start, count)
+		return
+	})
+	return
+}
+
+func (s *sparseSlaveUint8) WriteDiscreteInputs(id uint8, start uint16, b []byte) error {
+	return s.update(id, func(tables sparseSlaveTables) error { return tables.WriteDiscreteInputs(start, b) })
+}
+
+func (s *sparseSlaveUint8) WriteCoils(id uint8, start uint16, b []byte) error {
+	return s.update(id, func(tables sparseSlaveTables) error { return tables.WriteCoils(start, b) })
+}
+
+func (s *sparseSlaveUint8) WriteHoldingRegisters(id uint8, start uint16, b []uint16) error {
+	return s.update(id, func(tables sparseSlaveTables) error { return tables.WriteHoldingRegisters(start, b) })
+}
+
+func (s *sparseSlaveUint8) WriteInputRegisters(id uint8, start uint16, b []uint16) error {
+	return s.update(id, func(tables sparseSlaveTables) error { return tables.WriteInputRegisters(start, b) })
+}
+
+func (s *sparseSlaveUint8) Update(id uint8, fn func(tables SlaveTables) error) error {
+	return s.update(id, func(tables sparseSlaveTables) error { return updateSlaveTables(tables, fn) })
+}
+
+var _ SlaveTables = sparseSlaveTables{}
+
+// sparseSlaveTables are the tables of a slave of sparseSlaveUint8, the caller holds the slave's lock.
+type sparseSlaveTables struct {
+	*sparseSlave
+}
+
+func (t sparseSlaveTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	return t.discreteInputs.read(start, int(count))
+}
+
+func (t sparseSlaveTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	return t.coils.read(start, int(count))
+}
+
+func (t sparseSlaveTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	return t.holdingRegisters.read(start, int(count))
+}
+
+func (t sparseSlaveTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	return t.inputRegisters.read(start, int(count))
+}
+
+func (t sparseSlaveTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	return t.discreteInputs.write(start, values)
+}
+
+func (t sparseSlaveTables) WriteCoils(start uint16, values []byte) error {
+	return t.coils.write(start, values)
+}
+
+func (t sparseSlaveTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	return t.holdingRegisters.write(start, values)
+}
+
+func (t sparseSlaveTables) WriteInputRegisters(start uint16, values []uint16) error {
+	return t.inputRegisters.write(start, values)
+}
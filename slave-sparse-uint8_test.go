@@ -0,0 +1,199 @@
+package mbserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+// newTestSparseSlave returns slaves 1 to slaveNum with every address defined.
+func newTestSparseSlave(t *testing.T, slaveNum uint8) Slaver {
+	all := []AddressRange{{0, 65535}}
+	maps := make(map[uint8]SlaveMap)
+	for id := uint8(1); id <= slaveNum; id++ {
+		maps[id] = SlaveMap{DiscreteInputs: all, Coils: all, HoldingRegisters: all, InputRegisters: all}
+	}
+	slaver, err := NewSparseSlaveUint8(maps)
+	if err != nil {
+		t.Fatalf("NewSparseSlaveUint8() error = %v", err)
+	}
+	return slaver
+}
+
+func TestNewSparseSlaveUint8(t *testing.T) {
+	tests := []struct {
+		name    string
+		maps    map[uint8]SlaveMap
+		wantErr bool
+	}{
+		{
+			name: "test01",
+			maps: map[uint8]SlaveMap{
+				1: {HoldingRegisters: []AddressRange{{0, 9}, {5, 20}, {21, 21}, {100, 100}}},
+				3: {Coils: []AddressRange{{65535, 65535}}},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "broadcast address",
+			maps:    map[uint8]SlaveMap{0: {}},
+			wantErr: true,
+		},
+		{
+			name:    "range ends before it starts",
+			maps:    map[uint8]SlaveMap{1: {InputRegisters: []AddressRange{{10, 9}}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slaver, err := NewSparseSlaveUint8(tt.maps)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewSparseSlaveUint8() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			for id := 0; id < 256; id++ {
+				_, declared := tt.maps[uint8(id)]
+				if got := slaver.IsSlaveIdValid(uint8(id)); got != declared {
+					t.Errorf("sparseSlaveUint8.IsSlaveIdValid(%d) = %v, want %v", id, got, declared)
+				}
+			}
+		})
+	}
+}
+
+func Test_sparseSlaveUint8_ReadHoldingRegisters(t *testing.T) {
+	slaver, err := NewSparseSlaveUint8(map[uint8]SlaveMap{
+		1: {HoldingRegisters: []AddressRange{{0, 9}, {5, 20}, {22, 22}}},
+	})
+	if err != nil {
+		t.Fatalf("NewSparseSlaveUint8() error = %v", err)
+	}
+	s := slaver.(*sparseSlaveUint8)
+	if err := s.WriteHoldingRegisters(1, 19, []uint16{0x1234, 0xABCD}); err != nil {
+		t.Fatalf("sparseSlaveUint8.WriteHoldingRegisters() error = %v", err)
+	}
+
+	type args struct {
+		start uint16
+		count uint16
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantBs  []uint16
+		wantErr error
+	}{
+		{
+			name:   "merged ranges",
+			args:   args{start: 8, count: 13},
+			wantBs: []uint16{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x1234, 0xABCD},
+		},
+		{
+			name:   "single address",
+			args:   args{start: 22, count: 1},
+			wantBs: []uint16{0},
+		},
+		{
+			name:    "across a gap",
+			args:    args{start: 20, count: 3},
+			wantErr: IllegalDataAddress,
+		},
+		{
+			name:    "undefined address",
+			args:    args{start: 21, count: 1},
+			wantErr: IllegalDataAddress,
+		},
+		{
+			name:    "past the last range",
+			args:    args{start: 23, count: 1},
+			wantErr: IllegalDataAddress,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotBs, err := s.ReadHoldingRegisters(1, tt.args.start, tt.args.count)
+			if err != tt.wantErr {
+				t.Errorf("sparseSlaveUint8.ReadHoldingRegisters() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(gotBs, tt.wantBs) {
+				t.Errorf("sparseSlaveUint8.ReadHoldingRegisters() = %v, want %v", gotBs, tt.wantBs)
+			}
+		})
+	}
+
+	// A write touching an undefined address writes nothing.
+	if err := s.WriteHoldingRegisters(1, 20, []uint16{1, 2}); err != IllegalDataAddress {
+		t.Errorf("sparseSlaveUint8.WriteHoldingRegisters() error = %v, want %v", err, IllegalDataAddress)
+	}
+	if got, _ := s.ReadHoldingRegisters(1, 20, 1); got[0] != 0xABCD {
+		t.Errorf("sparseSlaveUint8.ReadHoldingRegisters() = %v, want [%v]", got, 0xABCD)
+	}
+
+	// The whole table only holds the defined addresses.
+	table, _ := s.HoldingRegisters(1)
+	table[21], table[22] = 1, 2
+	if err := s.SaveHoldingRegisters(1, table); err != nil {
+		t.Fatalf("sparseSlaveUint8.SaveHoldingRegisters() error = %v", err)
+	}
+	table, _ = s.HoldingRegisters(1)
+	if len(table) != 65536 || table[20] != 0xABCD || table[21] != 0 || table[22] != 2 {
+		t.Errorf("sparseSlaveUint8.HoldingRegisters() = %v, want 65536 registers with [20:23] = [%v 0 2]", table[20:23], 0xABCD)
+	}
+}
+
+func TestSparseSlaveIllegalDataAddress(t *testing.T) {
+	slaver, err := NewSparseSlaveUint8(map[uint8]SlaveMap{
+		1: {
+			Coils:            []AddressRange{{0, 7}},
+			DiscreteInputs:   []AddressRange{{0, 7}},
+			HoldingRegisters: []AddressRange{{0, 1}, {10, 11}},
+			InputRegisters:   []AddressRange{{0, 1}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSparseSlaveUint8() error = %v", err)
+	}
+	s := NewServer(slaver)
+
+	tests := []struct {
+		name     string
+		function uint8
+		data     []byte
+		want     Exception
+	}{
+		{"read coils", 1, []byte{0, 0, 0, 8}, Success},
+		{"read coils past the range", 1, []byte{0, 1, 0, 8}, IllegalDataAddress},
+		{"read discrete inputs past the range", 2, []byte{0, 8, 0, 1}, IllegalDataAddress},
+		{"read holding registers", 3, []byte{0, 10, 0, 2}, Success},
+		{"read holding registers across a gap", 3, []byte{0, 0, 0, 11}, IllegalDataAddress},
+		{"read input registers past the range", 4, []byte{0, 1, 0, 2}, IllegalDataAddress},
+		{"write single coil", 5, []byte{0, 8, 0xFF, 0}, IllegalDataAddress},
+		{"write single register", 6, []byte{0, 2, 0, 1}, IllegalDataAddress},
+		{"write multiple coils", 15, []byte{0, 6, 0, 3, 1, 0x07}, IllegalDataAddress},
+		{"write multiple registers", 16, []byte{0, 1, 0, 2, 4, 0, 1, 0, 2}, IllegalDataAddress},
+		{"mask write register", 22, []byte{0, 5, 0, 0, 0, 1}, IllegalDataAddress},
+		{"read write multiple registers", 23, []byte{0, 5, 0, 1, 0, 0, 0, 1, 2, 0, 1}, IllegalDataAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exception := GetException(handleRTU(s, tt.function, tt.data...))
+			if exception != tt.want {
+				t.Errorf("expected %v, got %v", tt.want.String(), exception.String())
+			}
+		})
+	}
+
+	// The rejected writes changed nothing.
+	holdingRegisters, _ := NewRangeSlaver(slaver).ReadHoldingRegisters(1, 0, 2)
+	if !reflect.DeepEqual(holdingRegisters, []uint16{0, 0}) {
+		t.Errorf("expected %v, got %v", []uint16{0, 0}, holdingRegisters)
+	}
+	coils, _ := NewRangeSlaver(slaver).ReadCoils(1, 0, 8)
+	if !reflect.DeepEqual(coils, make([]byte, 8)) {
+		t.Errorf("expected %v, got %v", make([]byte, 8), coils)
+	}
+}
@@ -0,0 +1,256 @@
+package mbserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// WordOrder is the order of the bytes of a value across registers, A is the
+// most significant byte of a 32 bits value, the orders extend to 64 bits values
+// word by word, like CDAB is GHEFCDAB.
+type WordOrder uint8
+
+const (
+	// ABCD is big endian, the high word first, like BytesToUint16.
+	ABCD WordOrder = iota
+	// CDAB is the low word first, the bytes of a word big endian.
+	CDAB
+	// BADC is the high word first, the bytes of a word little endian.
+	BADC
+	// DCBA is little endian.
+	DCBA
+)
+
+// String returns the name of the word order.
+func (o WordOrder) String() string {
+	switch o {
+	case ABCD:
+		return "ABCD"
+	case CDAB:
+		return "CDAB"
+	case BADC:
+		return "BADC"
+	case DCBA:
+		return "DCBA"
+	}
+	return fmt.Sprintf("WordOrder(%d)", uint8(o))
+}
+
+// ParseWordOrder returns the word order named s, ABCD, CDAB, BADC or DCBA.
+func ParseWordOrder(s string) (WordOrder, error) {
+	for _, order := range []WordOrder{ABCD, CDAB, BADC, DCBA} {
+		if order.String() == s {
+			return order, nil
+		}
+	}
+	return ABCD, errors.Errorf("unknown word order %q", s)
+}
+
+// wordSwapped reports if the order has the low word first.
+func (o WordOrder) wordSwapped() bool { return o == CDAB || o == DCBA }
+
+// byteSwapped reports if the order has the low byte of a word first.
+func (o WordOrder) byteSwapped() bool { return o == BADC || o == DCBA }
+
+// OrderedBytesToUint16 converts the big endian bytes of a value to registers
+// in order, an odd last byte is dropped.
+func OrderedBytesToUint16(b []byte, order WordOrder) []uint16 {
+	values := make([]uint16, len(b)/2)
+
+	for i := range values {
+		j := i
+		if order.wordSwapped() {
+			j = len(values) - 1 - i
+		}
+		if order.byteSwapped() {
+			values[j] = binary.LittleEndian.Uint16(b[i*2 : (i+1)*2])
+		} else {
+			values[j] = binary.BigEndian.Uint16(b[i*2 : (i+1)*2])
+		}
+	}
+	return values
+}
+
+// Uint16ToOrderedBytes converts registers in order to the big endian bytes of a value.
+func Uint16ToOrderedBytes(values []uint16, order WordOrder) []byte {
+	b := make([]byte, len(values)*2)
+
+	for i := range values {
+		j := i
+		if order.wordSwapped() {
+			j = len(values) - 1 - i
+		}
+		if order.byteSwapped() {
+			binary.LittleEndian.PutUint16(b[i*2:(i+1)*2], values[j])
+		} else {
+			binary.BigEndian.PutUint16(b[i*2:(i+1)*2], values[j])
+		}
+	}
+	return b
+}
+
+// RegisterAccessor gets and sets typed values across the registers of a table
+// of a Slaver, a value takes the registers from address its type needs.
+// The strings are ASCII, 2 characters per register, padded with NUL; only
+// the order of the bytes of a register applies to them, ABCD and CDAB put the
+// first character in the high byte, BADC and DCBA in the low byte.
+type RegisterAccessor interface {
+	GetUint32(id uint8, address uint16, order WordOrder) (uint32, error)
+	SetUint32(id uint8, address uint16, value uint32, order WordOrder) error
+	GetInt32(id uint8, address uint16, order WordOrder) (int32, error)
+	SetInt32(id uint8, address uint16, value int32, order WordOrder) error
+	GetUint64(id uint8, address uint16, order WordOrder) (uint64, error)
+	SetUint64(id uint8, address uint16, value uint64, order WordOrder) error
+	GetInt64(id uint8, address uint16, order WordOrder) (int64, error)
+	SetInt64(id uint8, address uint16, value int64, order WordOrder) error
+	GetFloat32(id uint8, address uint16, order WordOrder) (float32, error)
+	SetFloat32(id uint8, address uint16, value float32, order WordOrder) error
+	GetFloat64(id uint8, address uint16, order WordOrder) (float64, error)
+	SetFloat64(id uint8, address uint16, value float64, order WordOrder) error
+	// GetString returns the string of count registers, without the trailing NUL.
+	GetString(id uint8, address uint16, count uint16, order WordOrder) (string, error)
+	// SetString sets the count registers of the string, an error if value is not
+	// ASCII or longer than 2 * count characters.
+	SetString(id uint8, address uint16, count uint16, value string, order WordOrder) error
+}
+
+var _ RegisterAccessor = registerAccessor{}
+
+type registerAccessor struct {
+	slaver Slaver
+	table  Table
+}
+
+// NewRegisterAccessor returns the typed accessor of the holding registers or the
+// input registers of slaver, the writes of a value are one transaction, see SlaveUpdater.
+func NewRegisterAccessor(slaver Slaver, table Table) RegisterAccessor {
+	return registerAccessor{slaver: slaver, table: table}
+}
+
+// get returns the big endian bytes of the value of count registers from address.
+func (a registerAccessor) get(id uint8, address uint16, count uint16, order WordOrder) ([]byte, error) {
+
+	var values []uint16
+	var err error
+	switch a.table {
+	case TableHoldingRegisters:
+		values, err = NewRangeSlaver(a.slaver).ReadHoldingRegisters(id, address, count)
+	case TableInputRegisters:
+		values, err = NewRangeSlaver(a.slaver).ReadInputRegisters(id, address, count)
+	default:
+		err = errors.Errorf("%s has no registers", a.table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Uint16ToOrderedBytes(values, order), nil
+}
+
+// set sets the registers from address to the big endian bytes b of a value.
+func (a registerAccessor) set(id uint8, address uint16, b []byte, order WordOrder) error {
+
+	var values = OrderedBytesToUint16(b, order)
+	return updateSlaver(a.slaver, id, func(tables SlaveTables) error {
+		switch a.table {
+		case TableHoldingRegisters:
+			return tables.WriteHoldingRegisters(address, values)
+		case TableInputRegisters:
+			return tables.WriteInputRegisters(address, values)
+		}
+		return errors.Errorf("%s has no registers", a.table)
+	})
+}
+
+func (a registerAccessor) GetUint32(id uint8, address uint16, order WordOrder) (uint32, error) {
+	b, err := a.get(id, address, 2, order)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b), nil
+}
+
+func (a registerAccessor) SetUint32(id uint8, address uint16, value uint32, order WordOrder) error {
+	return a.set(id, address, binary.BigEndian.AppendUint32(nil, value), order)
+}
+
+func (a registerAccessor) GetInt32(id uint8, address uint16, order WordOrder) (int32, error) {
+	value, err := a.GetUint32(id, address, order)
+	return int32(value), err
+}
+
+func (a registerAccessor) SetInt32(id uint8, address uint16, value int32, order WordOrder) error {
+	return a.SetUint32(id, address, uint32(value), order)
+}
+
+func (a registerAccessor) GetUint64(id uint8, address uint16, order WordOrder) (uint64, error) {
+	b, err := a.get(id, address, 4, order)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (a registerAccessor) SetUint64(id uint8, address uint16, value uint64, order WordOrder) error {
+	return a.set(id, address, binary.BigEndian.AppendUint64(nil, value), order)
+}
+
+func (a registerAccessor) GetInt64(id uint8, address uint16, order WordOrder) (int64, error) {
+	value, err := a.GetUint64(id, address, order)
+	return int64(value), err
+}
+
+func (a registerAccessor) SetInt64(id uint8, address uint16, value int64, order WordOrder) error {
+	return a.SetUint64(id, address, uint64(value), order)
+}
+
+func (a registerAccessor) GetFloat32(id uint8, address uint16, order WordOrder) (float32, error) {
+	value, err := a.GetUint32(id, address, order)
+	return math.Float32frombits(value), err
+}
+
+func (a registerAccessor) SetFloat32(id uint8, address uint16, value float32, order WordOrder) error {
+	return a.SetUint32(id, address, math.Float32bits(value), order)
+}
+
+func (a registerAccessor) GetFloat64(id uint8, address uint16, order WordOrder) (float64, error) {
+	value, err := a.GetUint64(id, address, order)
+	return math.Float64frombits(value), err
+}
+
+func (a registerAccessor) SetFloat64(id uint8, address uint16, value float64, order WordOrder) error {
+	return a.SetUint64(id, address, math.Float64bits(value), order)
+}
+
+// stringOrder returns the order of the registers of a string, only the bytes of a register swap.
+func stringOrder(order WordOrder) WordOrder {
+	if order.byteSwapped() {
+		return BADC
+	}
+	return ABCD
+}
+
+func (a registerAccessor) GetString(id uint8, address uint16, count uint16, order WordOrder) (string, error) {
+	b, err := a.get(id, address, count, stringOrder(order))
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(b, "\x00")), nil
+}
+
+func (a registerAccessor) SetString(id uint8, address uint16, count uint16, value string, order WordOrder) error {
+	if len(value) > 2*int(count) {
+		return errors.Errorf("string of %d characters is longer than %d registers", len(value), count)
+	}
+	b := make([]byte, 2*int(count))
+	for i := 0; i < len(value); i++ {
+		if value[i] >= 0x80 {
+			return errors.Errorf("string %q is not ASCII", value)
+		}
+		b[i] = value[i]
+	}
+	return a.set(id, address, b, stringOrder(order))
+}
@@ -0,0 +1,211 @@
+package mbserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegisterAccessor(t *testing.T) {
+	type value struct {
+		set func(a RegisterAccessor, order WordOrder) error
+		get func(a RegisterAccessor, order WordOrder) (interface{}, error)
+	}
+	uint32Value := func(v uint32) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetUint32(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetUint32(1, 10, order) },
+		}
+	}
+	int32Value := func(v int32) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetInt32(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetInt32(1, 10, order) },
+		}
+	}
+	float32Value := func(v float32) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetFloat32(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetFloat32(1, 10, order) },
+		}
+	}
+	uint64Value := func(v uint64) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetUint64(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetUint64(1, 10, order) },
+		}
+	}
+	int64Value := func(v int64) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetInt64(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetInt64(1, 10, order) },
+		}
+	}
+	float64Value := func(v float64) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetFloat64(1, 10, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) { return a.GetFloat64(1, 10, order) },
+		}
+	}
+	stringValue := func(v string, count uint16) value {
+		return value{
+			set: func(a RegisterAccessor, order WordOrder) error { return a.SetString(1, 10, count, v, order) },
+			get: func(a RegisterAccessor, order WordOrder) (interface{}, error) {
+				return a.GetString(1, 10, count, order)
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		value     value
+		want      interface{}
+		wantWords map[WordOrder][]uint16
+	}{
+		{
+			name:  "uint32",
+			value: uint32Value(0x11223344),
+			want:  uint32(0x11223344),
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0x1122, 0x3344},
+				CDAB: {0x3344, 0x1122},
+				BADC: {0x2211, 0x4433},
+				DCBA: {0x4433, 0x2211},
+			},
+		},
+		{
+			name:  "int32",
+			value: int32Value(-2),
+			want:  int32(-2),
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0xFFFF, 0xFFFE},
+				CDAB: {0xFFFE, 0xFFFF},
+				BADC: {0xFFFF, 0xFEFF},
+				DCBA: {0xFEFF, 0xFFFF},
+			},
+		},
+		{
+			name:  "float32",
+			value: float32Value(123.456),
+			want:  float32(123.456),
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0x42F6, 0xE979},
+				CDAB: {0xE979, 0x42F6},
+				BADC: {0xF642, 0x79E9},
+				DCBA: {0x79E9, 0xF642},
+			},
+		},
+		{
+			name:  "uint64",
+			value: uint64Value(0x1122334455667788),
+			want:  uint64(0x1122334455667788),
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0x1122, 0x3344, 0x5566, 0x7788},
+				CDAB: {0x7788, 0x5566, 0x3344, 0x1122},
+				BADC: {0x2211, 0x4433, 0x6655, 0x8877},
+				DCBA: {0x8877, 0x6655, 0x4433, 0x2211},
+			},
+		},
+		{
+			name:  "int64",
+			value: int64Value(-2),
+			want:  int64(-2),
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0xFFFF, 0xFFFF, 0xFFFF, 0xFFFE},
+				CDAB: {0xFFFE, 0xFFFF, 0xFFFF, 0xFFFF},
+				BADC: {0xFFFF, 0xFFFF, 0xFFFF, 0xFEFF},
+				DCBA: {0xFEFF, 0xFFFF, 0xFFFF, 0xFFFF},
+			},
+		},
+		{
+			name:  "float64",
+			value: float64Value(1.5),
+			want:  1.5,
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0x3FF8, 0, 0, 0},
+				CDAB: {0, 0, 0, 0x3FF8},
+				BADC: {0xF83F, 0, 0, 0},
+				DCBA: {0, 0, 0, 0xF83F},
+			},
+		},
+		{
+			name:  "string",
+			value: stringValue("ABC", 3),
+			want:  "ABC",
+			wantWords: map[WordOrder][]uint16{
+				ABCD: {0x4142, 0x4300, 0},
+				CDAB: {0x4142, 0x4300, 0},
+				BADC: {0x4241, 0x0043, 0},
+				DCBA: {0x4241, 0x0043, 0},
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, order := range []WordOrder{ABCD, CDAB, BADC, DCBA} {
+			for _, table := range []Table{TableHoldingRegisters, TableInputRegisters} {
+				t.Run(tt.name+"/"+order.String()+"/"+table.String(), func(t *testing.T) {
+					slaver := NewMemorySlaveUint8(1)
+					a := NewRegisterAccessor(slaver, table)
+					if err := tt.value.set(a, order); err != nil {
+						t.Fatalf("set error = %v", err)
+					}
+					wantWords := tt.wantWords[order]
+					var words []uint16
+					if table == TableHoldingRegisters {
+						words, _ = NewRangeSlaver(slaver).ReadHoldingRegisters(1, 10, uint16(len(wantWords)))
+					} else {
+						words, _ = NewRangeSlaver(slaver).ReadInputRegisters(1, 10, uint16(len(wantWords)))
+					}
+					if !reflect.DeepEqual(words, wantWords) {
+						t.Errorf("registers = %04X, want %04X", words, wantWords)
+					}
+					got, err := tt.value.get(a, order)
+					if err != nil {
+						t.Fatalf("get error = %v", err)
+					}
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("get = %v, want %v", got, tt.want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestRegisterAccessorError(t *testing.T) {
+	slaver, err := NewSparseSlaveUint8(map[uint8]SlaveMap{1: {HoldingRegisters: []AddressRange{{0, 2}}}})
+	if err != nil {
+		t.Fatalf("NewSparseSlaveUint8() error = %v", err)
+	}
+	a := NewRegisterAccessor(slaver, TableHoldingRegisters)
+	if err = a.SetUint64(1, 0, 1, ABCD); err != IllegalDataAddress {
+		t.Errorf("SetUint64() error = %v, want %v", err, IllegalDataAddress)
+	}
+	if _, err = a.GetFloat32(1, 2, ABCD); err != IllegalDataAddress {
+		t.Errorf("GetFloat32() error = %v, want %v", err, IllegalDataAddress)
+	}
+	if err = a.SetString(1, 0, 1, "ABC", ABCD); err == nil {
+		t.Errorf("SetString() error = nil, want a too long string error")
+	}
+	if err = a.SetString(1, 0, 2, "é", ABCD); err == nil {
+		t.Errorf("SetString() error = nil, want a not ASCII error")
+	}
+	if err = NewRegisterAccessor(slaver, TableCoils).SetInt32(1, 0, 1, ABCD); err == nil {
+		t.Errorf("SetInt32() error = nil, want a no registers error")
+	}
+	// The failed writes wrote nothing.
+	values, _ := NewRangeSlaver(slaver).ReadHoldingRegisters(1, 0, 3)
+	if !reflect.DeepEqual(values, []uint16{0, 0, 0}) {
+		t.Errorf("ReadHoldingRegisters() = %v, want %v", values, []uint16{0, 0, 0})
+	}
+}
+
+func TestParseWordOrder(t *testing.T) {
+	for _, order := range []WordOrder{ABCD, CDAB, BADC, DCBA} {
+		if got, err := ParseWordOrder(order.String()); err != nil || got != order {
+			t.Errorf("ParseWordOrder(%q) = %v, %v, want %v", order.String(), got, err, order)
+		}
+	}
+	if _, err := ParseWordOrder("abcd"); err == nil {
+		t.Errorf("ParseWordOrder(%q) error = nil, want an error", "abcd")
+	}
+}
@@ -0,0 +1,221 @@
+package mbserver
+
+// tableWrite is a write of a transaction not applied yet.
+type tableWrite struct {
+	table     Table
+	start     uint16
+	bits      []byte
+	registers []uint16
+}
+
+var _ SlaveTables = new(slaveTransaction)
+
+// slaveTransaction buffers the writes to the tables of a slave until commit,
+// its reads see the buffered writes.
+type slaveTransaction struct {
+	tables SlaveTables
+	writes []tableWrite
+}
+
+// updateSlaveTables runs fn in a transaction on tables and applies its writes if fn returns nil.
+func updateSlaveTables(tables SlaveTables, fn func(tables SlaveTables) error) error {
+	tx := &slaveTransaction{tables: tables}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// updateSlaver runs fn in a transaction on the tables of slave id of slaver, see SlaveUpdater.
+// Without a SlaveUpdater the writes are still saved only if fn returns nil, but
+// the slave is not locked.
+func updateSlaver(slaver Slaver, id uint8, fn func(tables SlaveTables) error) error {
+	if updater, ok := slaver.(SlaveUpdater); ok {
+		return updater.Update(id, fn)
+	}
+	return updateSlaveTables(slaveRangeTables{NewRangeSlaver(slaver), id}, fn)
+}
+
+// updateHoldingRegisters runs fn on a copy of the holding registers of slave id
+// in a transaction of updater, then writes back the range fn changed, see HoldingRegistersUpdater.
+func updateHoldingRegisters(updater SlaveUpdater, id uint8, fn func(holdingRegisters []uint16) error) error {
+	return updater.Update(id, func(tables SlaveTables) error {
+
+		// A range holds at most 65535 registers, the table is read in halves.
+		low, err := tables.ReadHoldingRegisters(0, 32768)
+		if err != nil {
+			return err
+		}
+		high, err := tables.ReadHoldingRegisters(32768, 32768)
+		if err != nil {
+			return err
+		}
+		saved := append(low, high...)
+		values := CopyUint16(saved)
+		if err = fn(values); err != nil {
+			return err
+		}
+
+		first, last := 0, len(values)-1
+		for first <= last && values[first] == saved[first] {
+			first++
+		}
+		for last >= first && values[last] == saved[last] {
+			last--
+		}
+		if first > last {
+			return nil
+		}
+		return tables.WriteHoldingRegisters(uint16(first), values[first:last+1])
+	})
+}
+
+// overlayTableRange copies the part of a write from writeStart that overlaps values from start.
+func overlayTableRange[T byte | uint16](values []T, start uint16, write []T, writeStart uint16) {
+	low, high := max(int(start), int(writeStart)), min(int(start)+len(values), int(writeStart)+len(write))
+	if low < high {
+		copy(values[low-int(start):high-int(start)], write[low-int(writeStart):])
+	}
+}
+
+func (tx *slaveTransaction) readBits(table Table, start uint16, values []byte) []byte {
+	for _, write := range tx.writes {
+		if write.table == table {
+			overlayTableRange(values, start, write.bits, write.start)
+		}
+	}
+	return values
+}
+
+func (tx *slaveTransaction) readRegisters(table Table, start uint16, values []uint16) []uint16 {
+	for _, write := range tx.writes {
+		if write.table == table {
+			overlayTableRange(values, start, write.registers, write.start)
+		}
+	}
+	return values
+}
+
+func (tx *slaveTransaction) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	values, err := tx.tables.ReadDiscreteInputs(start, count)
+	if err != nil {
+		return nil, err
+	}
+	return tx.readBits(TableDiscreteInputs, start, values), nil
+}
+
+func (tx *slaveTransaction) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	values, err := tx.tables.ReadCoils(start, count)
+	if err != nil {
+		return nil, err
+	}
+	return tx.readBits(TableCoils, start, values), nil
+}
+
+func (tx *slaveTransaction) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	values, err := tx.tables.ReadHoldingRegisters(start, count)
+	if err != nil {
+		return nil, err
+	}
+	return tx.readRegisters(TableHoldingRegisters, start, values), nil
+}
+
+func (tx *slaveTransaction) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	values, err := tx.tables.ReadInputRegisters(start, count)
+	if err != nil {
+		return nil, err
+	}
+	return tx.readRegisters(TableInputRegisters, start, values), nil
+}
+
+func (tx *slaveTransaction) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	tx.writes = append(tx.writes, tableWrite{table: TableDiscreteInputs, start: start, bits: CopyBytes(values)})
+	return nil
+}
+
+func (tx *slaveTransaction) WriteCoils(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	tx.writes = append(tx.writes, tableWrite{table: TableCoils, start: start, bits: CopyBytes(values)})
+	return nil
+}
+
+func (tx *slaveTransaction) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	tx.writes = append(tx.writes, tableWrite{table: TableHoldingRegisters, start: start, registers: CopyUint16(values)})
+	return nil
+}
+
+func (tx *slaveTransaction) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	tx.writes = append(tx.writes, tableWrite{table: TableInputRegisters, start: start, registers: CopyUint16(values)})
+	return nil
+}
+
+// commit applies the writes in order.
+func (tx *slaveTransaction) commit() (err error) {
+	for _, write := range tx.writes {
+		switch write.table {
+		case TableDiscreteInputs:
+			err = tx.tables.WriteDiscreteInputs(write.start, write.bits)
+		case TableCoils:
+			err = tx.tables.WriteCoils(write.start, write.bits)
+		case TableHoldingRegisters:
+			err = tx.tables.WriteHoldingRegisters(write.start, write.registers)
+		case TableInputRegisters:
+			err = tx.tables.WriteInputRegisters(write.start, write.registers)
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+var _ SlaveTables = slaveRangeTables{}
+
+// slaveRangeTables are the tables of slave id of a RangeSlaver.
+type slaveRangeTables struct {
+	slaver RangeSlaver
+	id     uint8
+}
+
+func (t slaveRangeTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	return t.slaver.ReadDiscreteInputs(t.id, start, count)
+}
+
+func (t slaveRangeTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	return t.slaver.ReadCoils(t.id, start, count)
+}
+
+func (t slaveRangeTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	return t.slaver.ReadHoldingRegisters(t.id, start, count)
+}
+
+func (t slaveRangeTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	return t.slaver.ReadInputRegisters(t.id, start, count)
+}
+
+func (t slaveRangeTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	return t.slaver.WriteDiscreteInputs(t.id, start, values)
+}
+
+func (t slaveRangeTables) WriteCoils(start uint16, values []byte) error {
+	return t.slaver.WriteCoils(t.id, start, values)
+}
+
+func (t slaveRangeTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	return t.slaver.WriteHoldingRegisters(t.id, start, values)
+}
+
+func (t slaveRangeTables) WriteInputRegisters(start uint16, values []uint16) error {
+	return t.slaver.WriteInputRegisters(t.id, start, values)
+}
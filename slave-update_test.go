@@ -0,0 +1,123 @@
+package mbserver
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestSlaveUpdater(t *testing.T) {
+	slavers := map[string]func(t *testing.T) Slaver{
+		"memory": func(t *testing.T) Slaver { return NewMemorySlaveUint8(1) },
+		"file":   func(t *testing.T) Slaver { return NewFileSlaveUint8(1, t.TempDir()) },
+		"binary": func(t *testing.T) Slaver { return newTestBinaryFileSlave(t, 1, t.TempDir()) },
+		"bolt":   func(t *testing.T) Slaver { return newTestBoltSlave(t, 1) },
+		"sparse": func(t *testing.T) Slaver { return newTestSparseSlave(t, 1) },
+	}
+	for name, newSlaver := range slavers {
+		t.Run(name, func(t *testing.T) {
+			updater := newSlaver(t).(SlaveUpdater)
+
+			// The reads of a transaction see its writes, a failed transaction saves none.
+			errFn := errors.New("fn fails")
+			err := updater.Update(1, func(tables SlaveTables) error {
+				if err := tables.WriteHoldingRegisters(10, []uint16{1, 2, 3}); err != nil {
+					return err
+				}
+				if err := tables.WriteCoils(0, []byte{1}); err != nil {
+					return err
+				}
+				values, _ := tables.ReadHoldingRegisters(9, 3)
+				if expect := []uint16{0, 1, 2}; !isEqual(expect, values) {
+					t.Errorf("expected %v, got %v", expect, values)
+				}
+				return errFn
+			})
+			if err != errFn {
+				t.Errorf("expected %v, got %v", errFn, err)
+			}
+			values, _ := NewRangeSlaver(updater.(Slaver)).ReadHoldingRegisters(1, 10, 3)
+			if expect := []uint16{0, 0, 0}; !isEqual(expect, values) {
+				t.Errorf("expected %v, got %v", expect, values)
+			}
+
+			// Concurrent increments of one register are not lost.
+			var wg sync.WaitGroup
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						updater.Update(1, func(tables SlaveTables) error {
+							values, err := tables.ReadHoldingRegisters(0, 1)
+							if err != nil {
+								return err
+							}
+							return tables.WriteHoldingRegisters(0, []uint16{values[0] + 1})
+						})
+					}
+				}()
+			}
+			wg.Wait()
+			values, _ = NewRangeSlaver(updater.(Slaver)).ReadHoldingRegisters(1, 0, 1)
+			if values[0] != 80 {
+				t.Errorf("expected 80, got %v", values[0])
+			}
+		})
+	}
+}
+
+func TestSlaveTransactionOverlay(t *testing.T) {
+	tx := &slaveTransaction{tables: memorySlaveTables{NewMemorySlaveUint8(1).(*memorySlaveUint8), 0}}
+	tx.WriteInputRegisters(2, []uint16{1, 2, 3})
+	tx.WriteInputRegisters(3, []uint16{9})
+	tx.WriteHoldingRegisters(0, []uint16{7, 7, 7, 7, 7, 7})
+
+	values, _ := tx.ReadInputRegisters(0, 5)
+	if expect := []uint16{0, 0, 1, 9, 3}; !isEqual(expect, values) {
+		t.Errorf("expected %v, got %v", expect, values)
+	}
+	if err := tx.WriteCoils(65535, []byte{1, 1}); err == nil {
+		t.Errorf("expected error not nil, got %v", err)
+	}
+}
+
+func TestHoldingRegistersUpdater(t *testing.T) {
+	slavers := map[string]func(t *testing.T) Slaver{
+		"memory": func(t *testing.T) Slaver { return NewMemorySlaveUint8(2) },
+		"file":   func(t *testing.T) Slaver { return NewFileSlaveUint8(2, t.TempDir()) },
+	}
+	for name, newSlaver := range slavers {
+		t.Run(name, func(t *testing.T) {
+			slaver := newSlaver(t)
+			updater := slaver.(HoldingRegistersUpdater)
+
+			errFn := errors.New("fn fails")
+			err := updater.UpdateHoldingRegisters(2, func(holdingRegisters []uint16) error {
+				holdingRegisters[1] = 7
+				return errFn
+			})
+			if err != errFn {
+				t.Errorf("expected %v, got %v", errFn, err)
+			}
+			err = updater.UpdateHoldingRegisters(2, func(holdingRegisters []uint16) error {
+				if len(holdingRegisters) != 65536 {
+					t.Errorf("expected 65536 registers, got %d", len(holdingRegisters))
+				}
+				holdingRegisters[1] = 8
+				holdingRegisters[65535] = 9
+				return nil
+			})
+			if err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+			values, _ := slaver.HoldingRegisters(2)
+			if values[1] != 8 || values[2] != 0 || values[65535] != 9 {
+				t.Errorf("expected 8, 0 and 9, got %d, %d and %d", values[1], values[2], values[65535])
+			}
+			if values, _ := slaver.HoldingRegisters(1); values[1] != 0 {
+				t.Errorf("expected 0, got %d", values[1])
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package mbserver
 
-import "sync"
+import (
+	"fmt"
+	"sync"
+)
 
 // id in function definition is slave id when use tcp or rtu
 type Slaver interface {
@@ -15,7 +18,108 @@ type Slaver interface {
 	SaveInputRegisters(id uint8, b []uint16) error
 }
 
+// Table is one of the four tables of a slave.
+type Table uint8
+
+const (
+	TableDiscreteInputs Table = iota
+	TableCoils
+	TableHoldingRegisters
+	TableInputRegisters
+)
+
+// String returns the name of the table.
+func (t Table) String() string {
+	switch t {
+	case TableDiscreteInputs:
+		return "discreteInputs"
+	case TableCoils:
+		return "coils"
+	case TableHoldingRegisters:
+		return "holdingRegisters"
+	case TableInputRegisters:
+		return "inputRegisters"
+	}
+	return fmt.Sprintf("Table(%d)", uint8(t))
+}
+
+// RangeSlaver is implemented by a Slaver that reads and writes part of a table,
+// the built-in handlers then do not copy or save whole 65536 entry tables. A
+// range ends at most at entry 65536, coils and discrete inputs are 0 or 1 per
+// byte. NewRangeSlaver adapts any Slaver; id in function definition is slave id
+type RangeSlaver interface {
+	ReadDiscreteInputs(id uint8, start uint16, count uint16) ([]byte, error)
+	ReadCoils(id uint8, start uint16, count uint16) ([]byte, error)
+	ReadHoldingRegisters(id uint8, start uint16, count uint16) ([]uint16, error)
+	ReadInputRegisters(id uint8, start uint16, count uint16) ([]uint16, error)
+	WriteDiscreteInputs(id uint8, start uint16, values []byte) error
+	WriteCoils(id uint8, start uint16, values []byte) error
+	WriteHoldingRegisters(id uint8, start uint16, values []uint16) error
+	WriteInputRegisters(id uint8, start uint16, values []uint16) error
+}
+
+// FileRecorder stores the file records of Read File Record (function 20) and
+// Write File Record (function 21). Every file of a slave holds 10000 records,
+// numbered 0 to 9999, of one 16-bit register each; id in function definition is slave id
+type FileRecorder interface {
+	ReadFileRecords(id uint8, file uint16, record uint16, length uint16) ([]uint16, error)
+	WriteFileRecords(id uint8, file uint16, record uint16, values []uint16) error
+}
+
+// FIFOQueuer stores the FIFO queues of Read FIFO Queue (function 24), a queue
+// is addressed by its FIFO pointer address and holds at most 31 registers; id
+// in function definition is slave id
+type FIFOQueuer interface {
+	// PushFIFOQueue appends values to the queue, it fails if the queue would hold more than 31 registers.
+	PushFIFOQueue(id uint8, pointer uint16, values ...uint16) error
+	// FIFOQueue returns the registers of the queue, the oldest first, without removing them.
+	FIFOQueue(id uint8, pointer uint16) ([]uint16, error)
+	// PopFIFOQueue removes and returns up to count registers from the head of the queue.
+	PopFIFOQueue(id uint8, pointer uint16, count int) ([]uint16, error)
+}
+
+// SlaveTables are the tables of one slave inside a transaction of SlaveUpdater.
+// A range ends at most at entry 65536, coils and discrete inputs are 0 or 1 per byte.
+type SlaveTables interface {
+	ReadDiscreteInputs(start uint16, count uint16) ([]byte, error)
+	ReadCoils(start uint16, count uint16) ([]byte, error)
+	ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error)
+	ReadInputRegisters(start uint16, count uint16) ([]uint16, error)
+	WriteDiscreteInputs(start uint16, values []byte) error
+	WriteCoils(start uint16, values []byte) error
+	WriteHoldingRegisters(start uint16, values []uint16) error
+	WriteInputRegisters(start uint16, values []uint16) error
+}
+
+// SlaveUpdater is implemented by a Slaver that runs read-modify-write
+// transactions. Update holds the write lock of slave id while fn reads and
+// writes its tables, the writes are saved only if fn returns nil and fn must
+// not use tables after it returns; id in function definition is slave id
+type SlaveUpdater interface {
+	Update(id uint8, fn func(tables SlaveTables) error) error
+}
+
+// SlaveOriginUpdater is implemented by a SlaveUpdater that records who made
+// the changes of a transaction. The server updates it with the origin of the
+// request of a master, see Change.
+type SlaveOriginUpdater interface {
+	SlaveUpdater
+	// UpdateFrom is Update, origin describes who made the changes of fn.
+	UpdateFrom(id uint8, origin string, fn func(tables SlaveTables) error) error
+}
+
+// HoldingRegistersUpdater is implemented by a Slaver that can read, modify and
+// save the holding registers of a slave while holding the slave's write lock.
+// fn gets a copy of the registers, they are saved only if fn returns nil.
+// It is a transaction of Update on the holding registers.
+type HoldingRegistersUpdater interface {
+	UpdateHoldingRegisters(id uint8, fn func(holdingRegisters []uint16) error) error
+}
+
 var _ Slaver = new(memorySlaveUint8)
+var _ SlaveUpdater = new(memorySlaveUint8)
+var _ HoldingRegistersUpdater = new(memorySlaveUint8)
+var _ RangeSlaver = new(memorySlaveUint8)
 
 type memorySlaveUint8 struct {
 	slaveNum         uint8
@@ -140,6 +244,100 @@ func (s *memorySlaveUint8) SaveInputRegisters(id uint8, b []uint16) (err error)
 	return
 }
 
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) ReadDiscreteInputs(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = memorySlaveTables{s, id}.ReadDiscreteInputs(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) ReadCoils(id uint8, start uint16, count uint16) (bs []byte, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = memorySlaveTables{s, id}.ReadCoils(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) ReadHoldingRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = memorySlaveTables{s, id}.ReadHoldingRegisters(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) ReadInputRegisters(id uint8, start uint16, count uint16) (bs []uint16, err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].RLock()
+	bs, err = memorySlaveTables{s, id}.ReadInputRegisters(start, count)
+	s.slaveLock[id].RUnlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) WriteDiscreteInputs(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = memorySlaveTables{s, id}.WriteDiscreteInputs(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) WriteCoils(id uint8, start uint16, b []byte) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = memorySlaveTables{s, id}.WriteCoils(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) WriteHoldingRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = memorySlaveTables{s, id}.WriteHoldingRegisters(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) WriteInputRegisters(id uint8, start uint16, b []uint16) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = memorySlaveTables{s, id}.WriteInputRegisters(start, b)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+// if id not in [1, s.slaveNum], id > s.slaveNum => id will use slave ${slaveNum}, id < 1 => id will use slave 1
+func (s *memorySlaveUint8) Update(id uint8, fn func(tables SlaveTables) error) (err error) {
+
+	id = s.getRealId(id)
+	s.slaveLock[id].Lock()
+	err = updateSlaveTables(memorySlaveTables{s, id}, fn)
+	s.slaveLock[id].Unlock()
+	return
+}
+
+func (s *memorySlaveUint8) UpdateHoldingRegisters(id uint8, fn func(holdingRegisters []uint16) error) error {
+	return updateHoldingRegisters(s, id, fn)
+}
+
 func (s *memorySlaveUint8) getRealId(id uint8) (realId uint8) {
 
 	switch {
@@ -152,3 +350,71 @@ func (s *memorySlaveUint8) getRealId(id uint8) (realId uint8) {
 	}
 	return
 }
+
+var _ SlaveTables = memorySlaveTables{}
+
+// memorySlaveTables are the tables of a slave of memorySlaveUint8, the caller holds the slave's lock.
+type memorySlaveTables struct {
+	s      *memorySlaveUint8
+	realId uint8
+}
+
+func (t memorySlaveTables) ReadDiscreteInputs(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return readTableRange(t.s.discreteInputs[t.realId], start, count), nil
+}
+
+func (t memorySlaveTables) ReadCoils(start uint16, count uint16) ([]byte, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return readTableRange(t.s.coils[t.realId], start, count), nil
+}
+
+func (t memorySlaveTables) ReadHoldingRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return readTableRange(t.s.holdingRegisters[t.realId], start, count), nil
+}
+
+func (t memorySlaveTables) ReadInputRegisters(start uint16, count uint16) ([]uint16, error) {
+	if err := checkTableRange(start, int(count)); err != nil {
+		return nil, err
+	}
+	return readTableRange(t.s.inputRegisters[t.realId], start, count), nil
+}
+
+func (t memorySlaveTables) WriteDiscreteInputs(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	t.s.discreteInputs[t.realId] = writeTableRange(t.s.discreteInputs[t.realId], start, values)
+	return nil
+}
+
+func (t memorySlaveTables) WriteCoils(start uint16, values []byte) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	t.s.coils[t.realId] = writeTableRange(t.s.coils[t.realId], start, values)
+	return nil
+}
+
+func (t memorySlaveTables) WriteHoldingRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	t.s.holdingRegisters[t.realId] = writeTableRange(t.s.holdingRegisters[t.realId], start, values)
+	return nil
+}
+
+func (t memorySlaveTables) WriteInputRegisters(start uint16, values []uint16) error {
+	if err := checkTableRange(start, len(values)); err != nil {
+		return err
+	}
+	t.s.inputRegisters[t.realId] = writeTableRange(t.s.inputRegisters[t.realId], start, values)
+	return nil
+}